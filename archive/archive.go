@@ -0,0 +1,257 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+// アーカイブの種類
+type Format int
+
+const (
+	FormatUnknown Format = iota // アーカイブではない
+	FormatZip                   // zip
+	FormatTar                   // tar
+	FormatTarGz                 // gzip で圧縮した tar
+)
+
+// ファイル名(拡張子)からアーカイブの種類を判定する
+func DetectFormat(name string) Format {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return FormatZip
+	case strings.HasSuffix(name, ".tar"):
+		return FormatTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return FormatTarGz
+	}
+	return FormatUnknown
+}
+
+// アーカイブ内のエントリ
+type Entry struct {
+	Name string      // アーカイブ内の相対パス('/' 区切り)
+	Info fs.FileInfo // サイズ、更新日時、種類
+
+	open func() (io.ReadCloser, error)
+}
+
+// エントリの内容を読み込む(tar の場合は Walk に渡した関数の中でのみ読み込める)
+func (e *Entry) Open() (io.ReadCloser, error) {
+	return e.open()
+}
+
+// コピー元のアーカイブ
+type Archive struct {
+	path   string
+	format Format
+	zip    *zip.ReadCloser
+
+	mu         sync.Mutex
+	invalid    []string
+	duplicated []string
+}
+
+// アーカイブを開く(zip は目次を読み込む)
+func Open(file string) (*Archive, error) {
+	a := &Archive{path: file, format: DetectFormat(file)}
+	switch a.format {
+	case FormatZip:
+		zr, err := zip.OpenReader(file)
+		if err != nil {
+			return nil, err
+		}
+		a.zip = zr
+	case FormatTar, FormatTarGz:
+		if _, err := os.Stat(file); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("対応していないアーカイブです: %s", file)
+	}
+	return a, nil
+}
+
+func (a *Archive) Close() error {
+	if a.zip != nil {
+		return a.zip.Close()
+	}
+	return nil
+}
+
+// エントリを並行して読み込めるか(zip は可、tar は先頭から順に読み込む)
+func (a *Archive) RandomAccess() bool {
+	return a.format == FormatZip
+}
+
+// 不正なパスのため処理しなかったエントリを取得する
+func (a *Archive) Invalid() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string{}, a.invalid...)
+}
+
+// 同じパスのため処理しなかったエントリ(後のエントリで上書きされる)を取得する
+func (a *Archive) Duplicated() []string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]string{}, a.duplicated...)
+}
+
+// アーカイブ内のエントリを先頭から順に処理する
+// (アーカイブの外を指すパスのエントリは処理せずに記録する)
+// (同じパスのエントリが複数ある場合は展開した結果と同じになるよう最後のエントリのみ処理する)
+func (a *Archive) Walk(fn func(*Entry) error) error {
+	a.mu.Lock()
+	a.invalid = nil
+	a.duplicated = nil
+	a.mu.Unlock()
+	if a.zip != nil {
+		return a.walkZip(fn)
+	}
+	return a.walkTar(fn)
+}
+
+func (a *Archive) walkZip(fn func(*Entry) error) error {
+	last := map[string]int{}
+	for i, f := range a.zip.File {
+		if name, ok := cleanName(f.Name, f.NonUTF8); ok {
+			last[name] = i
+		}
+	}
+
+	for i, f := range a.zip.File {
+		entry := &Entry{Info: f.FileInfo(), open: f.Open}
+		if !a.setName(entry, f.Name, f.NonUTF8) || !a.isLast(entry, i, last) {
+			continue
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *Archive) walkTar(fn func(*Entry) error) error {
+	// 同じパスのエントリを確認するため、先にヘッダーのみ読み込む
+	last := map[string]int{}
+	err := a.readTar(func(i int, hdr *tar.Header, tr *tar.Reader) error {
+		if name, ok := cleanName(hdr.Name, true); ok {
+			last[name] = i
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.readTar(func(i int, hdr *tar.Header, tr *tar.Reader) error {
+		entry := &Entry{
+			Info: hdr.FileInfo(),
+			open: func() (io.ReadCloser, error) { return io.NopCloser(tr), nil },
+		}
+		if !a.setName(entry, hdr.Name, true) || !a.isLast(entry, i, last) {
+			return nil
+		}
+		return fn(entry)
+	})
+}
+
+// tar のヘッダーを先頭から順に読み込む
+func (a *Archive) readTar(fn func(int, *tar.Header, *tar.Reader) error) error {
+	file, err := os.Open(a.path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	var r io.Reader = file
+	if a.format == FormatTarGz {
+		zr, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	tr := tar.NewReader(r)
+	for i := 0; ; i++ {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(i, hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// 同じパスの最後のエントリかチェックする(最後ではないファイルは記録する)
+func (a *Archive) isLast(entry *Entry, i int, last map[string]int) bool {
+	if last[entry.Name] == i {
+		return true
+	}
+	if !entry.Info.IsDir() {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.duplicated = append(a.duplicated, entry.Name)
+	}
+	return false
+}
+
+// エントリのパスを設定する(処理しないエントリはfalse)
+func (a *Archive) setName(entry *Entry, name string, nonUTF8 bool) bool {
+	cleaned, ok := cleanName(name, nonUTF8)
+	if !ok {
+		a.mu.Lock()
+		defer a.mu.Unlock()
+		a.invalid = append(a.invalid, name)
+		return false
+	}
+	entry.Name = cleaned
+	return cleaned != "."
+}
+
+// アーカイブ内のパスを相対パスに変換する
+// (UTF-8 ではない名前は Shift-JIS として変換、アーカイブの外を指すパスは不正)
+func cleanName(name string, nonUTF8 bool) (string, bool) {
+	if nonUTF8 && !utf8.ValidString(name) {
+		name = DecodeShiftJIS(name)
+	}
+
+	// Windows で作成したアーカイブは '\' 区切りの場合がある(Shift-JIS の変換後に置き換える)
+	name = strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(name, "/") {
+		return name, false
+	}
+	name = path.Clean(name)
+	if name == "." {
+		return name, true
+	}
+	return name, fs.ValidPath(name)
+}
+
+// Shift-JIS(Windows-31J)の文字列を UTF-8 に変換する
+func DecodeShiftJIS(s string) string {
+	decoded, err := japanese.ShiftJIS.NewDecoder().String(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
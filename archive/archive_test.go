@@ -0,0 +1,120 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// エントリのパスと内容を取得する
+func readAll(t *testing.T, file string) (map[string]string, []string) {
+	a, err := Open(file)
+	assert.NoError(t, err, "Open")
+	defer a.Close()
+
+	contents := map[string]string{}
+	err = a.Walk(func(entry *Entry) error {
+		if entry.Info.IsDir() {
+			contents[entry.Name+"/"] = ""
+			return nil
+		}
+		r, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		contents[entry.Name] = string(data)
+		return err
+	})
+	assert.NoError(t, err, "Walk")
+	return contents, a.Invalid()
+}
+
+func TestZip(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.zip")
+	f, err := os.Create(file)
+	assert.NoError(t, err)
+
+	// Shift-JIS のファイル名(2バイト目が '\' の「ソ」を含む)、Windows の '\' 区切り
+	sjis, err := japanese.ShiftJIS.NewEncoder().String(`日本語\ソフト.txt`)
+	assert.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for _, hdr := range []*zip.FileHeader{
+		{Name: sjis, NonUTF8: true},
+		{Name: "utf8/日本語.txt"},
+		{Name: "dir/"},
+		{Name: "../evil.txt"},
+	} {
+		w, err := zw.CreateHeader(hdr)
+		assert.NoError(t, err, hdr.Name)
+		if hdr.Name != "dir/" {
+			_, err = w.Write([]byte(hdr.Name))
+			assert.NoError(t, err)
+		}
+	}
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, f.Close())
+
+	contents, invalid := readAll(t, file)
+	assert.Equal(t, map[string]string{
+		"日本語/ソフト.txt":  sjis,
+		"utf8/日本語.txt": "utf8/日本語.txt",
+		"dir/":         "",
+	}, contents)
+	assert.Equal(t, []string{"../evil.txt"}, invalid, "アーカイブの外を指すパス")
+}
+
+func TestTarGz(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "test.tar.gz")
+	f, err := os.Create(file)
+	assert.NoError(t, err)
+
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	zw := gzip.NewWriter(f)
+	tw := tar.NewWriter(zw)
+	for _, hdr := range []*tar.Header{
+		{Name: "./", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "./a/", Typeflag: tar.TypeDir, Mode: 0755},
+		{Name: "./a/b.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 3, ModTime: mtime},
+		{Name: "/etc/passwd", Typeflag: tar.TypeReg, Mode: 0644, Size: 3},
+		{Name: "a/b.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 3, ModTime: mtime},
+	} {
+		assert.NoError(t, tw.WriteHeader(hdr), hdr.Name)
+		if hdr.Size > 0 {
+			_, err := tw.Write([]byte(hdr.Name[:3]))
+			assert.NoError(t, err)
+		}
+	}
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, f.Close())
+
+	contents, invalid := readAll(t, file)
+	assert.Equal(t, map[string]string{"a/": "", "a/b.txt": "a/b"}, contents, "同じパスは最後のエントリ")
+	assert.Equal(t, []string{"/etc/passwd"}, invalid, "絶対パス")
+
+	a, err := Open(file)
+	assert.NoError(t, err)
+	defer a.Close()
+	assert.NoError(t, a.Walk(func(entry *Entry) error { return nil }))
+	assert.Equal(t, []string{"a/b.txt"}, a.Duplicated(), "同じパスのエントリ")
+}
+
+func TestDetectFormat(t *testing.T) {
+	assert.Equal(t, FormatZip, DetectFormat("a.ZIP"))
+	assert.Equal(t, FormatTar, DetectFormat("a.tar"))
+	assert.Equal(t, FormatTarGz, DetectFormat("a.tar.gz"))
+	assert.Equal(t, FormatTarGz, DetectFormat("a.tgz"))
+	assert.Equal(t, FormatUnknown, DetectFormat("a.txt"))
+	_, err := Open("a.txt")
+	assert.Error(t, err)
+}
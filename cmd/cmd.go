@@ -1,22 +1,103 @@
 package cmd
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/coco-papiyon/mechacopy/worker"
 )
 
-func Args(argLen int) (*worker.Config, []string) {
-	var logger *slog.Logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
-	slog.SetDefault(logger)
+// 複数回指定できるオプション
+type ListFlag []string
+
+func (l *ListFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *ListFlag) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// サイズを解析する(K/M/G/T の単位を指定可)
+func parseSize(value string) (int64, error) {
+	units := map[byte]int64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40}
+	value = strings.TrimSuffix(strings.ToUpper(value), "B")
+	unit := int64(1)
+	if len(value) > 0 {
+		if u, ok := units[value[len(value)-1]]; ok {
+			unit = u
+			value = value[:len(value)-1]
+		}
+	}
+	size, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return size * unit, nil
+}
+
+// 経過期間を解析する(1900未満は日数、それ以外は YYYYMMDD 形式の日付)
+func parseAge(value string) (time.Duration, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n < 1900 {
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	date, err := time.ParseInLocation("20060102", value, time.Local)
+	if err != nil {
+		return 0, err
+	}
+	return time.Since(date), nil
+}
 
+// ログの出力先(引数の解析後に引数を渡して決める)
+// 結果を標準出力に出力するコマンドは、解析前に標準エラー出力を返すよう変更する
+var LogOutput = func(args []string) io.Writer {
+	return os.Stdout
+}
+
+// ログを標準エラー出力に出力する(LogOutput に指定する)
+func Stderr(args []string) io.Writer {
+	return os.Stderr
+}
+
+func Args(argLen int) (*worker.Config, []string) {
 	// オプションの定義
 	mt := flag.Int("MT", 0, "n 個のスレッドのマルチスレッド コピーを実行する (既定値 10)")
 	retry := flag.Int("R", 0, "失敗したコピーに対する再試行数 (既定値 10)")
 	wait := flag.Int("W", 0, "試行と再試行の間の待機時間 (既定値 10)")
+	scan := flag.Int("SCAN", 0, "n 個のスレッドでディレクトリを走査する (既定値 4)")
+	lev := flag.Int("LEV", 0, "コピー元ディレクトリ ツリーの上位 n レベルのみをコピーする")
+	onefs := flag.Bool("ONEFS", false, "マウントポイントを越えてディレクトリを走査しない")
+	var xf, xd, filterFrom ListFlag
+	flag.Var(&xf, "XF", "指定されたパターンに一致するファイルを除外する (複数指定可)")
+	flag.Var(&xd, "XD", "指定されたパターンに一致するディレクトリを除外する (複数指定可)")
+	flag.Var(&filterFrom, "FILTERFROM", "ファイルから対象/除外ルールを読み込む (gitignore/rsyncフィルタ形式、複数指定可)")
+	ignore := flag.Bool("MECHAIGNORE", false, "各ディレクトリの .mechaignore ファイルのルールを適用する")
+	minSize := flag.String("MIN", "", "n バイトより小さいファイルを除外する (K/M/G 単位可)")
+	maxSize := flag.String("MAX", "", "n バイトより大きいファイルを除外する (K/M/G 単位可)")
+	minAge := flag.String("MINAGE", "", "n 日 (または YYYYMMDD) より新しいファイルを除外する")
+	maxAge := flag.String("MAXAGE", "", "n 日 (または YYYYMMDD) より古いファイルを除外する")
+	xo := flag.Bool("XO", false, "コピー先より古いファイルを除外する (上書きしない)")
+	xn := flag.Bool("XN", false, "コピー先より新しいファイルを除外する (上書きしない)")
+	xc := flag.Bool("XC", false, "更新日時が同じでサイズが異なるファイルを除外する (上書きしない)")
+	noOverwrite := flag.Bool("NOOVERWRITE", false, "コピー先に存在しないファイルのみコピーする (上書きしない)")
+	backup := flag.Bool("BACKUP", false, "上書きするファイルを「ファイル名~」として退避する")
+	backupDir := flag.String("BACKUPDIR", "", "上書きするファイルを指定ディレクトリ以下に退避する")
+	backupTS := flag.Bool("BACKUPTS", false, "上書きするファイルを「ファイル名.日時」として世代管理する")
+	backupKeep := flag.Int("BACKUPKEEP", 0, "ファイルごとに残すバックアップの世代数 (既定値 無制限、-BACKUPTS)")
+	snapshot := flag.Bool("SNAPSHOT", false, "コピー先に日時のディレクトリを作成し、前回のスナップショットとの差分のみコピーする")
+	linkDest := flag.String("LINKDEST", "", "差分がないファイルは指定ディレクトリのファイルへのハードリンクを作成する")
 
 	// Usageの出力
 	flag.Usage = func() {
@@ -24,6 +105,7 @@ func Args(argLen int) (*worker.Config, []string) {
 		fmt.Fprintf(os.Stderr, "           コピー元 :: コピー元ディレクトリ\n")
 		fmt.Fprintf(os.Stderr, "           コピー先 :: コピー先ディレクトリ\n")
 		fmt.Fprintf(os.Stderr, "           ファイル :: コピーするファイル (名前/ワイルドカード: 既定値は「*」\n")
+		fmt.Fprintf(os.Stderr, "                       '/' を含むパターンはコピー元からの相対パス、'**' は任意の階層、'!' は除外\n")
 		flag.PrintDefaults()
 	}
 
@@ -37,6 +119,10 @@ func Args(argLen int) (*worker.Config, []string) {
 		os.Exit(1)
 	}
 
+	// オプションの設定もログの出力先に出力する
+	logger := slog.New(slog.NewTextHandler(LogOutput(args), nil))
+	slog.SetDefault(logger)
+
 	config := worker.InitConfig()
 	if *mt > 0 {
 		config.CopyThread = *mt
@@ -50,5 +136,128 @@ func Args(argLen int) (*worker.Config, []string) {
 		config.SleepTime = *wait
 		logger.Info(fmt.Sprintf("リトライ待機時間: %d", *wait))
 	}
+	if *scan > 0 {
+		config.ScanThread = *scan
+		logger.Info(fmt.Sprintf("走査スレッド数: %d", *scan))
+	}
+	if *lev > 0 {
+		config.MaxDepth = *lev
+		logger.Info(fmt.Sprintf("走査レベル: %d", *lev))
+	}
+	if *onefs {
+		config.OneFS = true
+		logger.Info("マウントポイントを越えない")
+	}
+	if len(xf) > 0 {
+		config.ExcludeFiles = xf
+		logger.Info(fmt.Sprintf("除外ファイル: %v", xf))
+	}
+	if len(xd) > 0 {
+		config.ExcludeDirs = xd
+		logger.Info(fmt.Sprintf("除外ディレクトリ: %v", xd))
+	}
+	if len(filterFrom) > 0 {
+		config.FilterFrom = filterFrom
+		logger.Info(fmt.Sprintf("ルールファイル: %v", filterFrom))
+	}
+	if *ignore {
+		config.IgnoreFile = ".mechaignore"
+		logger.Info(fmt.Sprintf("ディレクトリごとのルールファイル: %s", config.IgnoreFile))
+	}
+	if *minSize != "" {
+		config.MinSize = mustParse(parseSize, "MIN", *minSize)
+		logger.Info(fmt.Sprintf("最小サイズ: %d", config.MinSize))
+	}
+	if *maxSize != "" {
+		config.MaxSize = mustParse(parseSize, "MAX", *maxSize)
+		logger.Info(fmt.Sprintf("最大サイズ: %d", config.MaxSize))
+	}
+	if *minAge != "" {
+		config.MinAge = mustParse(parseAge, "MINAGE", *minAge)
+		logger.Info(fmt.Sprintf("最小経過期間: %v", config.MinAge))
+	}
+	if *maxAge != "" {
+		config.MaxAge = mustParse(parseAge, "MAXAGE", *maxAge)
+		logger.Info(fmt.Sprintf("最大経過期間: %v", config.MaxAge))
+	}
+	if *xo {
+		config.ExcludeOlder = true
+		logger.Info("古いファイルを除外")
+	}
+	if *xn {
+		config.ExcludeNewer = true
+		logger.Info("新しいファイルを除外")
+	}
+	if *xc {
+		config.ExcludeChanged = true
+		logger.Info("変更されたファイルを除外")
+	}
+	if *noOverwrite {
+		config.NoOverwrite = true
+		logger.Info("上書きしない")
+	}
+	if *backup {
+		config.Backup = true
+		logger.Info("上書きするファイルを退避")
+	}
+	if *backupDir != "" {
+		config.BackupDir = *backupDir
+		logger.Info(fmt.Sprintf("バックアップ先: %s", *backupDir))
+	}
+	if *backupTS {
+		config.BackupTimestamp = true
+		logger.Info("バックアップを世代管理")
+	}
+	if *backupKeep > 0 {
+		if !*backupTS {
+			fmt.Fprintln(os.Stderr, "-BACKUPKEEP は -BACKUPTS と同時に指定してください")
+			flag.Usage()
+			os.Exit(1)
+		}
+		config.BackupKeep = *backupKeep
+		logger.Info(fmt.Sprintf("バックアップ世代数: %d", *backupKeep))
+	}
+	if *snapshot {
+		config.Snapshot = true
+		logger.Info("スナップショット")
+	}
+	if *linkDest != "" {
+		config.LinkDest = *linkDest
+		logger.Info(fmt.Sprintf("リンク元: %s", *linkDest))
+	}
 	return config, args
 }
+
+// オプションの値を解析する(不正な値の場合は終了する)
+func mustParse[T any](parse func(string) (T, error), name, value string) T {
+	v, err := parse(value)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "-%s の値が不正です: %s\n", name, value)
+		flag.Usage()
+		os.Exit(1)
+	}
+	return v
+}
+
+// 削除対象の数が上限を超える場合、確認で中止した場合は終了する
+func CheckDelete(count, maxDel int64, yes bool) {
+	if maxDel > 0 && count > maxDel {
+		fmt.Fprintf(os.Stderr, "削除対象の数が上限 (%d) を超えるため中止します\n", maxDel)
+		os.Exit(1)
+	}
+	if !yes && !confirm() {
+		fmt.Println("中止しました")
+		os.Exit(1)
+	}
+}
+
+// 削除してよいか確認する
+func confirm() bool {
+	fmt.Print("削除しますか? [y/N]: ")
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
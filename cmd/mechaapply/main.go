@@ -0,0 +1,40 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"github.com/coco-papiyon/mechacopy/cmd"
+	"github.com/coco-papiyon/mechacopy/worker"
+)
+
+func main() {
+	// 引数を取得
+	config, args := cmd.Args(1)
+	file := args[0]
+
+	// 計画を読み込む
+	plan, err := readPlan(file)
+	if err != nil {
+		slog.Error("計画の読み込み", "ERROR", err, "file", file)
+		os.Exit(1)
+	}
+
+	// 計画を実行
+	slog.Info("Start Apply", "計画", file, "コピー元", plan.Source, "コピー先", plan.Destination, "操作数", len(plan.Items))
+	err = worker.ApplyPlan(plan, config)
+	if err != nil {
+		slog.Error("計画の実行", "ERROR", err)
+		os.Exit(1)
+	}
+}
+
+// 計画ファイルを読み込む
+func readPlan(file string) (*worker.Plan, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return worker.ReadPlan(f)
+}
@@ -1,13 +1,53 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
 	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
 
+	"github.com/coco-papiyon/mechacopy/archive"
 	"github.com/coco-papiyon/mechacopy/cmd"
+	"github.com/coco-papiyon/mechacopy/snapshot"
 	"github.com/coco-papiyon/mechacopy/worker"
 )
 
 func main() {
+	// ファイル一覧のオプション
+	filesFrom := flag.String("FILESFROM", "", "ファイルから読み込んだファイルのみコピーする (「-」は標準入力、改行または NUL 区切り)")
+
+	// 複数のコピー先
+	var dests cmd.ListFlag
+	flag.Var(&dests, "DEST", "追加のコピー先 (複数指定可、コピー元は一度だけ読み込む)")
+
+	// 複数のコピー元
+	var srcs cmd.ListFlag
+	flag.Var(&srcs, "SRC", "追加のコピー元 (「コピー元=サブディレクトリ」でコピー先のサブディレクトリにコピー、最後の = で分割、複数指定可)")
+
+	// アーカイブのオプション
+	tarOut := flag.Bool("TAR", false, "コピー先を tar アーカイブのファイルとして書き込む (コピー先が「-」の場合は標準出力)")
+	gzipOut := flag.Bool("GZIP", false, "tar アーカイブを gzip で圧縮する (コピー先が .gz/.tgz の場合は指定不要、-TAR)")
+
+	// 監視のオプション
+	mon := flag.Bool("MON", false, "コピー後もコピー元を監視し、変更があったディレクトリを再コピーする (Ctrl+C で終了)")
+	debounce := flag.Int("DEBOUNCE", 2, "最後の変更から再コピーまでの待機時間 (秒、-MON)")
+	rescan := flag.Int("RESCAN", int(worker.DefaultRescan/time.Minute), "全体を再コピーする間隔 (分、0 は再コピーしない、変更を監視できない場合は既定値、-MON)")
+
+	// tar アーカイブを標準出力に出力する場合は、ログを標準エラー出力に出力する
+	cmd.LogOutput = func(args []string) io.Writer {
+		if *tarOut && len(args) > 1 && args[1] == "-" {
+			return os.Stderr
+		}
+		return os.Stdout
+	}
+
 	// 引数を取得
 	config, args := cmd.Args(2)
 	src := args[0]
@@ -18,11 +58,156 @@ func main() {
 	if len(extraArgs) > 0 {
 		config.TargetFiles = extraArgs
 	}
-	var runner worker.Runner = &worker.CopyRunner{
+
+	// スナップショットの場合は前回のスナップショットをリンク元にする
+	if config.Snapshot {
+		if config.LinkDest == "" {
+			if prev, ok := snapshot.Latest(dst); ok {
+				config.LinkDest = prev.Path
+				slog.Info("前回のスナップショット", "リンク元", prev.Path)
+			}
+		}
+		dst = filepath.Join(dst, snapshot.Name(time.Now()))
+	}
+	var runner worker.FileRunner = &worker.CopyRunner{
 		Destination: dst,
 	}
+	if len(dests) > 0 {
+		if config.Snapshot || config.LinkDest != "" || config.BackupDir != "" {
+			fmt.Fprintln(os.Stderr, "-DEST と -SNAPSHOT/-LINKDEST/-BACKUPDIR は同時に指定できません")
+			os.Exit(1)
+		}
+		runner = &worker.MultiCopyRunner{Destinations: append([]string{dst}, dests...)}
+		dst = strings.Join(append([]string{dst}, dests...), ", ")
+	}
+
+	// アーカイブ (zip/tar/tar.gz) をコピー元としてコピーする
+	if info, err := os.Stat(src); err == nil && !info.IsDir() && archive.DetectFormat(src) != archive.FormatUnknown {
+		if len(dests) > 0 || len(srcs) > 0 || *filesFrom != "" || *mon || *tarOut || config.Snapshot || config.LinkDest != "" {
+			fmt.Fprintln(os.Stderr, "アーカイブのコピー元と -DEST/-SRC/-FILESFROM/-MON/-TAR/-SNAPSHOT/-LINKDEST は同時に指定できません")
+			os.Exit(1)
+		}
+		slog.Info("Start Copy", "コピー元", src, "コピー先", dst, "対象ファイル", config.TargetFiles)
+		err := worker.RunArchive(src, dst, config)
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// tar アーカイブに書き込む
+	if *tarOut {
+		if len(dests) > 0 || len(srcs) > 0 || *filesFrom != "" || *mon || config.Snapshot || config.LinkDest != "" {
+			fmt.Fprintln(os.Stderr, "-TAR と -DEST/-SRC/-FILESFROM/-MON/-SNAPSHOT/-LINKDEST は同時に指定できません")
+			os.Exit(1)
+		}
+		err := writeTar(src, dst, *gzipOut, config)
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// 複数のコピー元を統合してコピーする
+	if len(srcs) > 0 {
+		if len(dests) > 0 || *filesFrom != "" || *mon {
+			fmt.Fprintln(os.Stderr, "-SRC と -DEST/-FILESFROM/-MON は同時に指定できません")
+			os.Exit(1)
+		}
+		sources := []worker.MergeSource{worker.ParseMergeSource(src)}
+		for _, s := range srcs {
+			sources = append(sources, worker.ParseMergeSource(s))
+		}
+		slog.Info("Start Merge", "コピー元", append([]string{src}, srcs...), "コピー先", dst, "対象ファイル", config.TargetFiles)
+		collisions, err := worker.RunMerge(sources, dst, config)
+		if err != nil {
+			slog.Error("コピー元の統合", "ERROR", err)
+			os.Exit(1)
+		}
+		if len(collisions) > 0 {
+			slog.Warn("同じコピー先のファイルがあります", "Count", len(collisions))
+		}
+		return
+	}
+
+	// ファイル一覧のファイルのみコピーする
+	if *filesFrom != "" {
+		files, err := readFileList(*filesFrom)
+		if err != nil {
+			slog.Error("ファイル一覧の読み込み", "ERROR", err, "file", *filesFrom)
+			os.Exit(1)
+		}
+		slog.Info("Start Copy", "コピー元", src, "コピー先", dst, "ファイル数", len(files))
+		worker.RunFiles(src, files, runner, config)
+		return
+	}
+
+	// 変更を監視してコピーする
+	if *mon {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		monitor := &worker.Monitor{
+			Debounce: time.Duration(*debounce) * time.Second,
+			Rescan:   time.Duration(*rescan) * time.Minute,
+		}
+		slog.Info("Start Monitor", "コピー元", src, "コピー先", dst, "対象ファイル", config.TargetFiles)
+		err := monitor.Run(ctx, src, runner, config)
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
 
 	// コピーを実行
 	slog.Info("Start Copy", "コピー元", src, "コピー先", dst, "対象ファイル", config.TargetFiles)
 	worker.RunMecha(src, runner, config)
 }
+
+// ファイル一覧を読み込む(「-」の場合は標準入力)
+func readFileList(file string) ([]string, error) {
+	if file == "-" {
+		return worker.ReadFileList(os.Stdin)
+	}
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return worker.ReadFileList(f)
+}
+
+// コピー元を tar アーカイブに書き込む(「-」の場合は標準出力)
+func writeTar(src, dst string, gz bool, config *worker.Config) error {
+	runner := &worker.TarRunner{
+		Writer: os.Stdout,
+		Gzip:   gz || strings.HasSuffix(dst, ".gz") || strings.HasSuffix(dst, ".tgz"),
+	}
+	var f *os.File
+	if dst == "-" {
+		// アーカイブを標準出力に出力するため、集計結果は出力しない
+		config.Quiet = true
+	} else {
+		var err error
+		f, err = os.Create(dst)
+		if err != nil {
+			slog.Error("アーカイブの作成", "ERROR", err, "file", dst)
+			return err
+		}
+		runner.Writer = f
+	}
+
+	slog.Info("Start Archive", "コピー元", src, "コピー先", dst, "対象ファイル", config.TargetFiles)
+	err := worker.RunMecha(src, runner, config)
+	if err == nil {
+		err = runner.Err()
+	}
+	if f != nil {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		slog.Error("アーカイブの書き込み", "ERROR", err, "file", dst)
+	}
+	return err
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// テストバイナリをコマンドとして実行する場合は main を実行する
+func TestMain(m *testing.M) {
+	if os.Getenv("MECHACOPY_TEST_MAIN") == "1" {
+		os.Args = append([]string{os.Args[0]}, os.Args[2:]...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// コマンドを実行し、標準出力を取得する
+func runMain(t *testing.T, args ...string) []byte {
+	cmd := exec.Command(os.Args[0], append([]string{"--"}, args...)...)
+	cmd.Env = append(os.Environ(), "MECHACOPY_TEST_MAIN=1")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	assert.NoError(t, cmd.Run(), stderr.String())
+	return stdout.Bytes()
+}
+
+func TestTarStdout(t *testing.T) {
+	srcDir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(srcDir, "a"), os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "a", "b.txt"), []byte("bbb"), 0644))
+	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "c.txt"), []byte("ccc"), 0644))
+
+	// ログが出力されるオプションを指定しても、標準出力はアーカイブのみ
+	stdout := runMain(t, "-TAR", "-MT", "2", "-XF", "*.log", srcDir, "-")
+	tr := tar.NewReader(bytes.NewReader(stdout))
+	names := []string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err, "tar") {
+			return
+		}
+		names = append(names, hdr.Name)
+	}
+	assert.Equal(t, []string{"a/", "a/b.txt", "c.txt"}, names)
+
+	// アーカイブの終端の後に余分な出力がない
+	rest, err := io.ReadAll(tr)
+	assert.NoError(t, err)
+	assert.Empty(t, rest)
+}
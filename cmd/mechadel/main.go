@@ -1,21 +1,129 @@
 package main
 
 import (
+	"flag"
+	"fmt"
 	"log/slog"
+	"os"
+	"slices"
 
 	"github.com/coco-papiyon/mechacopy/cmd"
+	"github.com/coco-papiyon/mechacopy/filecopy"
+	"github.com/coco-papiyon/mechacopy/trash"
 	"github.com/coco-papiyon/mechacopy/worker"
 )
 
 func main() {
+	// 削除の安全確認のオプション
+	var protect cmd.ListFlag
+	flag.Var(&protect, "PROTECT", "削除を禁止するディレクトリ (複数指定可、ホームディレクトリは常に保護)")
+	yes := flag.Bool("YES", false, "確認せずに削除する")
+	maxDel := flag.Int64("MAXDEL", 0, "削除対象のファイルが n 個を超える場合は中止する (-EMPTYDIRS はディレクトリと無視するファイルの合計)")
+	useTrash := flag.Bool("TRASH", false, "削除せずにゴミ箱 (XDG Trash) へ移動する")
+	trashDir := flag.String("TRASHDIR", "", "削除せずに指定ディレクトリへ相対パスを維持して移動する")
+	wipe := flag.Int("WIPE", 0, "削除前にファイルの内容を n 回上書きする")
+	wipeRandom := flag.Bool("WIPERANDOM", false, "上書きに乱数を使用する (既定値は 0 で上書き)")
+	emptyDirs := flag.Bool("EMPTYDIRS", false, "ファイルは削除せず、空のディレクトリのみ削除する")
+	junk := flag.Bool("JUNK", false, "Thumbs.db、.DS_Store 等のみを含むディレクトリも空として扱う (-EMPTYDIRS)")
+	var junkFiles cmd.ListFlag
+	flag.Var(&junkFiles, "JUNKFILE", "空ディレクトリの判定で無視するファイル (複数指定可、-EMPTYDIRS)")
+
 	// 引数を取得
 	config, args := cmd.Args(1)
 	src := args[0]
+	extraArgs := args[1:]
+	var err error
 
 	// 動作設定
+	if len(extraArgs) > 0 {
+		config.TargetFiles = extraArgs
+	}
 	config.Retry = false
-	var runner worker.Runner = &worker.DeleteRunner{}
+	runner := &worker.DeleteRunner{}
+	if *trashDir != "" {
+		runner.Trash = trash.New(*trashDir)
+	} else if *useTrash {
+		runner.Trash, err = trash.XDG()
+		if err != nil {
+			slog.Error("ゴミ箱", "ERROR", err)
+			os.Exit(1)
+		}
+	}
+
+	// 空ディレクトリの削除はゴミ箱への移動、上書きに対応していない
+	if *emptyDirs && (runner.Trash != nil || *wipe > 0) {
+		fmt.Fprintln(os.Stderr, "-EMPTYDIRS と -TRASH/-TRASHDIR/-WIPE は同時に指定できません")
+		os.Exit(1)
+	}
+
+	if *wipe > 0 {
+		if runner.Trash != nil {
+			fmt.Fprintln(os.Stderr, "-WIPE と -TRASH/-TRASHDIR は同時に指定できません")
+			os.Exit(1)
+		}
+		runner.Wipe = &filecopy.Wipe{Passes: *wipe, Random: *wipeRandom}
+		slog.Info("上書きしてから削除", "回数", *wipe, "乱数", *wipeRandom)
+	}
+
+	// 削除してよいディレクトリかチェック(ゴミ箱を含むディレクトリも削除しない)
+	protected := append(worker.DefaultProtectedPaths(), protect...)
+	if runner.Trash != nil {
+		protected = append(protected, runner.Trash.Dir)
+		slog.Info("ゴミ箱へ移動", "ゴミ箱", runner.Trash.Dir)
+	}
+	err = worker.CheckDeleteTarget(src, protected)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// 空ディレクトリのみ削除
+	if *emptyDirs {
+		if *junk {
+			junkFiles = slices.Concat(worker.DefaultJunkFiles, junkFiles)
+		}
+		dirs, files, err := worker.CountEmptyDirs(src, config, junkFiles)
+		if err != nil {
+			slog.Error("削除対象の確認", "ERROR", err, "basePath", src)
+			os.Exit(1)
+		}
+		fmt.Printf("削除対象: %s (%d ディレクトリ, %d ファイル)\n", src, dirs, files)
+		cmd.CheckDelete(dirs+files, *maxDel, *yes)
+		removeEmptyDirs(src, config, junkFiles)
+		return
+	}
+
+	// 削除対象のファイル数を確認
+	files, bytes, err := worker.CountTargetFiles(src, config)
+	if err != nil {
+		slog.Error("削除対象の確認", "ERROR", err, "basePath", src)
+		os.Exit(1)
+	}
+	fmt.Printf("削除対象: %s (%d ファイル, %d バイト)\n", src, files, bytes)
+	cmd.CheckDelete(files, *maxDel, *yes)
+
+	slog.Info("Start Delete", "削除対象", src, "対象ファイル", config.TargetFiles)
+	err = worker.RunMecha(src, runner, config)
+	if err != nil || runner.Failed() > 0 {
+		os.Exit(1)
+	}
+}
 
-	slog.Info("Start Delete", "削除対象", src)
-	worker.RunMecha(src, runner, config)
+// 空ディレクトリを削除して結果を出力する
+func removeEmptyDirs(src string, config *worker.Config, junkFiles []string) {
+	slog.Info("Start Delete Empty Directories", "削除対象", src, "無視するファイル", junkFiles)
+	removed, errDirs, err := worker.RemoveEmptyDirs(src, config, junkFiles)
+	if err != nil {
+		slog.Error("空ディレクトリ削除", "ERROR", err, "basePath", src)
+		os.Exit(1)
+	}
+	fmt.Printf("    Removed: %d\n", removed)
+	fmt.Printf("    ErrDir:  %d\n", len(errDirs))
+	if len(errDirs) > 0 {
+		fmt.Printf("ERROR Directories\n")
+		for _, dir := range errDirs {
+			fmt.Printf("  %s\n", dir)
+		}
+		os.Exit(1)
+	}
 }
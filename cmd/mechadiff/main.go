@@ -0,0 +1,83 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/coco-papiyon/mechacopy/cmd"
+	"github.com/coco-papiyon/mechacopy/worker"
+)
+
+// 終了コード
+const (
+	exitMatch = 0 // 一致
+	exitDiff  = 1 // 差分あり
+	exitError = 2 // エラー
+)
+
+func main() {
+	// 比較のオプション
+	hash := flag.Bool("HASH", false, "サイズが同じファイルの内容をハッシュ値で比較する")
+	jsonOut := flag.Bool("JSON", false, "比較結果を JSON 形式で出力する")
+	out := flag.String("OUT", "", "比較結果の出力先ファイル (既定値は標準出力)")
+
+	// 比較結果を標準出力に出力するため、ログは標準エラー出力に出力する
+	cmd.LogOutput = cmd.Stderr
+
+	// 引数を取得
+	config, args := cmd.Args(2)
+	src := args[0]
+	dst := args[1]
+	extraArgs := args[2:]
+
+	// 動作設定
+	if len(extraArgs) > 0 {
+		config.TargetFiles = extraArgs
+	}
+	config.Retry = false
+	config.Quiet = true
+	runner := &worker.DiffRunner{
+		Destination: dst,
+		Hash:        *hash,
+	}
+
+	// 比較を実行
+	slog.Info("Start Compare", "コピー元", src, "コピー先", dst, "対象ファイル", config.TargetFiles)
+	err := worker.RunMecha(src, runner, config)
+	if err != nil {
+		os.Exit(exitError)
+	}
+
+	// 比較結果を出力
+	w := os.Stdout
+	if *out != "" {
+		w, err = os.Create(*out)
+		if err != nil {
+			slog.Error("出力先ファイル", "ERROR", err, "file", *out)
+			os.Exit(exitError)
+		}
+	}
+	report := runner.Report()
+	if *jsonOut {
+		err = report.WriteJSON(w)
+	} else {
+		err = report.WriteText(w)
+	}
+	if *out != "" {
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		slog.Error("比較結果の出力", "ERROR", err)
+		os.Exit(exitError)
+	}
+
+	switch {
+	case len(report.Errors) > 0:
+		os.Exit(exitError)
+	case !report.Match:
+		os.Exit(exitDiff)
+	}
+}
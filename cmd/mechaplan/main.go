@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/coco-papiyon/mechacopy/cmd"
+	"github.com/coco-papiyon/mechacopy/worker"
+)
+
+func main() {
+	// 計画のオプション
+	del := flag.Bool("DELETE", false, "コピー先のみに存在するファイル、ディレクトリの削除も計画する (ミラー)")
+	out := flag.String("OUT", "", "計画の出力先ファイル (既定値は標準出力)")
+	text := flag.Bool("TEXT", false, "計画を JSON 形式ではなく一覧で出力する (確認用)")
+
+	// 計画を標準出力に出力するため、ログは標準エラー出力に出力する
+	cmd.LogOutput = cmd.Stderr
+
+	// 引数を取得
+	config, args := cmd.Args(2)
+	extraArgs := args[2:]
+
+	// 実行時のカレントディレクトリに依存しないよう絶対パスにする
+	src, err := filepath.Abs(args[0])
+	if err != nil {
+		slog.Error("コピー元", "ERROR", err)
+		os.Exit(1)
+	}
+	dst, err := filepath.Abs(args[1])
+	if err != nil {
+		slog.Error("コピー先", "ERROR", err)
+		os.Exit(1)
+	}
+
+	// 動作設定
+	if len(extraArgs) > 0 {
+		config.TargetFiles = extraArgs
+	}
+	config.Retry = false
+	config.Quiet = true
+	runner := &worker.PlanRunner{Destination: dst, Delete: *del}
+
+	// 計画を作成
+	slog.Info("Start Plan", "コピー元", src, "コピー先", dst, "対象ファイル", config.TargetFiles)
+	err = worker.RunMecha(src, runner, config)
+	if err != nil {
+		os.Exit(1)
+	}
+	plan := runner.Plan(src)
+
+	// 計画を出力
+	w := os.Stdout
+	if *out != "" {
+		w, err = os.Create(*out)
+		if err != nil {
+			slog.Error("出力先ファイル", "ERROR", err, "file", *out)
+			os.Exit(1)
+		}
+	}
+	if *text {
+		err = plan.WriteText(w)
+	} else {
+		err = worker.WritePlan(w, plan)
+	}
+	if *out != "" {
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		slog.Error("計画の出力", "ERROR", err)
+		os.Exit(1)
+	}
+	slog.Info("Plan Finished", "操作数", len(plan.Items), "エラー", len(plan.Errors))
+	if len(plan.Errors) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/coco-papiyon/mechacopy/cmd"
+	"github.com/coco-papiyon/mechacopy/snapshot"
+	"github.com/coco-papiyon/mechacopy/worker"
+)
+
+func main() {
+	// 保持ポリシーのオプション
+	last := flag.Int("LAST", 0, "最新から n 個のスナップショットを保持する")
+	daily := flag.Int("DAILY", 0, "直近 n 日分の各日の最新のスナップショットを保持する")
+	weekly := flag.Int("WEEKLY", 0, "直近 n 週分の各週の最新のスナップショットを保持する")
+	monthly := flag.Int("MONTHLY", 0, "直近 n か月分の各月の最新のスナップショットを保持する")
+	dryRun := flag.Bool("DRYRUN", false, "削除せずに保持/削除するスナップショットの一覧のみ出力する")
+
+	// 削除の安全確認のオプション
+	var protect cmd.ListFlag
+	flag.Var(&protect, "PROTECT", "削除を禁止するディレクトリ (複数指定可、ホームディレクトリは常に保護)")
+	yes := flag.Bool("YES", false, "確認せずに削除する")
+	maxDel := flag.Int64("MAXDEL", 0, "削除対象のファイルが n 個を超える場合は中止する")
+
+	// 引数を取得
+	config, args := cmd.Args(1)
+	root := args[0]
+
+	policy := snapshot.Policy{
+		Last:    *last,
+		Daily:   *daily,
+		Weekly:  *weekly,
+		Monthly: *monthly,
+	}
+	if policy.IsEmpty() {
+		fmt.Fprintln(os.Stderr, "保持ポリシー (-LAST/-DAILY/-WEEKLY/-MONTHLY) を指定してください")
+		os.Exit(1)
+	}
+
+	// スナップショット一覧を取得
+	snapshots, err := snapshot.List(root)
+	if err != nil {
+		slog.Error("スナップショット取得", "ERROR", err, "basePath", root)
+		os.Exit(1)
+	}
+
+	// 保持/削除するスナップショットを出力
+	keep, expire := policy.Apply(snapshots)
+	for _, s := range keep {
+		fmt.Printf("KEEP    %s\n", s.Path)
+	}
+	for _, s := range expire {
+		fmt.Printf("EXPIRE  %s\n", s.Path)
+	}
+	if *dryRun {
+		return
+	}
+
+	if len(expire) == 0 {
+		return
+	}
+
+	// 削除の設定(ファイルパターン等の指定によらずスナップショット内をすべて削除する)
+	delConfig := worker.InitConfig()
+	delConfig.CopyThread = config.CopyThread
+	delConfig.ScanThread = config.ScanThread
+	delConfig.Quiet = config.Quiet
+	delConfig.Retry = false
+
+	// 削除してよいディレクトリか、削除対象のファイル数を確認
+	protected := append(worker.DefaultProtectedPaths(), protect...)
+	var files, bytes int64
+	for _, s := range expire {
+		err = worker.CheckDeleteTarget(s.Path, protected)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		f, b, err := worker.CountTargetFiles(s.Path, delConfig)
+		if err != nil {
+			slog.Error("削除対象の確認", "ERROR", err, "basePath", s.Path)
+			os.Exit(1)
+		}
+		files += f
+		bytes += b
+	}
+	fmt.Printf("削除対象: %d スナップショット (%d ファイル, %d バイト)\n", len(expire), files, bytes)
+	cmd.CheckDelete(files, *maxDel, *yes)
+
+	// 期限切れのスナップショットを削除(空になったスナップショットのディレクトリも削除する)
+	failed := false
+	for _, s := range expire {
+		slog.Info("Start Delete", "削除対象", s.Path)
+		runner := &worker.DeleteRunner{RemoveEmptyDirs: true}
+		err := worker.RunMecha(s.Path, runner, delConfig)
+		if err == nil && runner.Failed() == 0 {
+			err = os.Remove(s.Path)
+		}
+		if err != nil || runner.Failed() > 0 {
+			slog.Error("スナップショット削除", "ERROR", err, "path", s.Path)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
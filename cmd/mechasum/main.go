@@ -0,0 +1,106 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+
+	"github.com/coco-papiyon/mechacopy/cmd"
+	"github.com/coco-papiyon/mechacopy/worker"
+)
+
+// 終了コード
+const (
+	exitOK    = 0 // 正常(検証で問題なし)
+	exitFail  = 1 // 検証で問題あり
+	exitError = 2 // エラー
+)
+
+func main() {
+	// マニフェストのオプション
+	jsonOut := flag.Bool("JSON", false, "マニフェストを JSON 形式で出力する")
+	out := flag.String("OUT", "", "マニフェスト、検証結果の出力先ファイル (既定値は標準出力)")
+	verify := flag.String("VERIFY", "", "指定したマニフェスト (sha256sum/JSON 形式) でディレクトリを検証する")
+
+	// 結果を標準出力に出力するため、ログは標準エラー出力に出力する
+	cmd.LogOutput = cmd.Stderr
+
+	// 引数を取得
+	config, args := cmd.Args(1)
+	src := args[0]
+	extraArgs := args[1:]
+
+	// 動作設定
+	if len(extraArgs) > 0 {
+		config.TargetFiles = extraArgs
+	}
+	config.Retry = false
+	config.Quiet = true
+	runner := &worker.ChecksumRunner{}
+	for _, file := range []string{*out, *verify} {
+		if file != "" {
+			runner.ManifestFiles = append(runner.ManifestFiles, file)
+		}
+	}
+	if *verify != "" {
+		manifest, err := readManifest(*verify)
+		if err != nil {
+			slog.Error("マニフェストの読み込み", "ERROR", err, "file", *verify)
+			os.Exit(exitError)
+		}
+		runner.Expected = manifest
+	}
+
+	// ハッシュ値を取得
+	slog.Info("Start Checksum", "対象ディレクトリ", src, "対象ファイル", config.TargetFiles)
+	err := worker.RunMecha(src, runner, config)
+	if err != nil {
+		os.Exit(exitError)
+	}
+
+	// 結果を出力
+	w := os.Stdout
+	if *out != "" {
+		w, err = os.Create(*out)
+		if err != nil {
+			slog.Error("出力先ファイル", "ERROR", err, "file", *out)
+			os.Exit(exitError)
+		}
+	}
+	report := runner.Report()
+	switch {
+	case *verify != "":
+		err = report.WriteText(w)
+	case *jsonOut:
+		err = worker.WriteManifestJSON(w, runner.Entries())
+	default:
+		err = worker.WriteManifest(w, runner.Entries())
+	}
+	if *out != "" {
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		slog.Error("結果の出力", "ERROR", err)
+		os.Exit(exitError)
+	}
+
+	switch {
+	case len(report.Errors) > 0:
+		os.Exit(exitError)
+	case !report.OK():
+		os.Exit(exitFail)
+	}
+	os.Exit(exitOK)
+}
+
+// マニフェストファイルを読み込む
+func readManifest(file string) (map[string]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return worker.ReadManifest(f)
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+
+	"github.com/coco-papiyon/mechacopy/cmd"
+	"github.com/coco-papiyon/mechacopy/worker"
+)
+
+func main() {
+	// 同期のオプション
+	state := flag.String("STATE", "", "前回の同期の状態ファイル (既定値は $XDG_STATE_HOME/mechacopy 以下)")
+	conflict := flag.String("CONFLICT", string(worker.ConflictReport), "両方で変更されたファイルの扱い (newer: 新しい方を残す, keep: 古い方も名前を変えて残す, report: 報告のみ)")
+	dryRun := flag.Bool("DRYRUN", false, "同期する内容を表示するのみで実行しない")
+	maxDel := flag.Int("MAXDEL", 0, "削除するファイルが n 個を超える場合は中止する")
+	yes := flag.Bool("YES", false, "片方のディレクトリが空の場合、-MAXDEL を超える場合も同期する")
+
+	// 引数を取得
+	config, args := cmd.Args(2)
+	extraArgs := args[2:]
+
+	// 動作設定
+	if len(extraArgs) > 0 {
+		config.TargetFiles = extraArgs
+	}
+	mode := worker.ConflictMode(*conflict)
+	switch mode {
+	case worker.ConflictNewer, worker.ConflictKeepBoth, worker.ConflictReport:
+	default:
+		fmt.Fprintf(os.Stderr, "-CONFLICT には newer, keep, report のいずれかを指定してください: %s\n", *conflict)
+		os.Exit(1)
+	}
+	bisync := &worker.BiSync{A: args[0], B: args[1], State: *state, Conflict: mode, DryRun: *dryRun,
+		MaxDelete: *maxDel, Force: *yes}
+	if bisync.State == "" {
+		var err error
+		bisync.State, err = worker.DefaultStatePath(bisync.A, bisync.B)
+		if err != nil {
+			slog.Error("状態ファイル", "ERROR", err)
+			os.Exit(1)
+		}
+	}
+
+	// 同期を実行
+	slog.Info("Start Sync", "A", bisync.A, "B", bisync.B, "状態ファイル", bisync.State, "競合", mode)
+	report, err := bisync.Run(config)
+	if err != nil {
+		// 試行の場合は同期する内容と中止する理由を表示する
+		if bisync.DryRun && report != nil {
+			report.WriteText(os.Stdout)
+			fmt.Fprintf(os.Stderr, "実行すると中止します: %v\n", err)
+		}
+		os.Exit(1)
+	}
+	report.WriteText(os.Stdout)
+	if len(report.Errors) > 0 || (mode == worker.ConflictReport && len(report.Conflicts) > 0) {
+		os.Exit(1)
+	}
+}
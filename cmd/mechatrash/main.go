@@ -0,0 +1,108 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/coco-papiyon/mechacopy/trash"
+)
+
+func main() {
+	var logger *slog.Logger = slog.New(slog.NewTextHandler(os.Stdout, nil))
+	slog.SetDefault(logger)
+
+	// オプションの定義
+	trashDir := flag.String("TRASHDIR", "", "隔離用ディレクトリ (既定値は XDG Trash)")
+
+	// Usageの出力
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "使い方: %s [オプション] コマンド [パス [パス]...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "           list    :: ゴミ箱のファイル一覧を出力する\n")
+		fmt.Fprintf(os.Stderr, "           restore :: ファイルを元の場所に戻す (パス以下のファイルのみ: 既定値はすべて)\n")
+		fmt.Fprintf(os.Stderr, "           empty   :: ゴミ箱を空にする (パス以下のファイルのみ: 既定値はすべて)\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	args := flag.Args()
+	if len(args) < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	// ゴミ箱を取得
+	t := trash.New(*trashDir)
+	if *trashDir == "" {
+		var err error
+		t, err = trash.XDG()
+		if err != nil {
+			slog.Error("ゴミ箱", "ERROR", err)
+			os.Exit(1)
+		}
+	}
+
+	items, err := t.List()
+	if err != nil {
+		slog.Error("ゴミ箱の一覧", "ERROR", err, "ゴミ箱", t.Dir)
+		os.Exit(1)
+	}
+	items = filterItems(items, args[1:])
+
+	errCnt := 0
+	switch args[0] {
+	case "list":
+		for _, item := range items {
+			fmt.Printf("%s  %s\n", item.DeletionDate.Format("2006-01-02 15:04:05"), item.Path)
+		}
+	case "restore":
+		for _, item := range items {
+			err := t.Restore(item)
+			if err != nil {
+				slog.Error("Restore", "file", item.Path, "ERROR", err)
+				errCnt++
+				continue
+			}
+			slog.Info("Restore", "file", item.Path)
+		}
+	case "empty":
+		for _, item := range items {
+			err := t.Remove(item)
+			if err != nil {
+				slog.Error("Empty", "file", item.Path, "ERROR", err)
+				errCnt++
+			}
+		}
+		slog.Info("Empty", "ゴミ箱", t.Dir, "件数", len(items)-errCnt)
+	default:
+		flag.Usage()
+		os.Exit(1)
+	}
+	if errCnt > 0 {
+		os.Exit(1)
+	}
+}
+
+// 指定パス以下のファイルのみに絞り込む(指定がない場合はすべて)
+func filterItems(items []trash.Item, paths []string) []trash.Item {
+	if len(paths) == 0 {
+		return items
+	}
+	result := []trash.Item{}
+	for _, item := range items {
+		for _, path := range paths {
+			abs, err := filepath.Abs(path)
+			if err != nil {
+				continue
+			}
+			if item.Path == abs || strings.HasPrefix(item.Path, abs+string(filepath.Separator)) {
+				result = append(result, item)
+				break
+			}
+		}
+	}
+	return result
+}
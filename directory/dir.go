@@ -1,45 +1,172 @@
 package directory
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"sync"
 )
 
-// 再帰的にディレクトリを取得(同時実行制御)
-func getDirWorker(basedir, dirname string, wg *sync.WaitGroup, ch chan<- []string) {
-	defer wg.Done()
-	dir, err := getDirRecursion(basedir, dirname)
+// ディレクトリ走査(同時実行数を制限し、見つけたディレクトリを順次送信する)
+type Walker struct {
+	Threads       int  // 走査スレッド数
+	MaxDepth      int  // 走査する階層数(起点が1階層目、0は無制限)
+	OneFileSystem bool // マウントポイントを越えて走査しない
+
+	// 除外するディレクトリの判定(起点ディレクトリからの相対パス)
+	SkipDir func(dirname string) bool
+	// ディレクトリを読み込んだ時の処理(子ディレクトリの走査より先に呼び出す)
+	VisitDir func(dirname string) error
+
+	device    uint64
+	hasDevice bool
+
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	sem    chan struct{}
+	ch     chan<- string
+	errors []DirError
+}
+
+// 走査できなかったディレクトリ
+type DirError struct {
+	Path string // 起点ディレクトリからの相対パス
+	Err  error
+}
+
+func (e DirError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+// Walkerを作成する
+func NewWalker(threads int) *Walker {
+	if threads < 1 {
+		threads = 1
+	}
+	return &Walker{
+		Threads: threads,
+	}
+}
+
+// 指定ディレクトリ以下のディレクトリを見つけた順にchへ送信する
+// (chはクローズしない。戻り値は指定ディレクトリ自体のエラーのみ)
+func (w *Walker) Walk(path string, ch chan<- string) error {
+	// 直下のディレクトリを取得
+	subDirs, err := getSubDirs(path)
 	if err != nil {
-		slog.Error("ディレクトリ取得エラー", "ERROR", err, "Directory", dirname)
-		return
+		return err
 	}
-	ch <- dir
+
+	w.ch = ch
+	w.sem = make(chan struct{}, w.Threads)
+	if w.OneFileSystem {
+		w.device, w.hasDevice = deviceID(path)
+	}
+	if w.VisitDir != nil {
+		if err := w.VisitDir("."); err != nil {
+			return err
+		}
+	}
+	ch <- "."
+	if w.MaxDepth == 1 {
+		return nil
+	}
+	for _, sub := range subDirs {
+		w.walkChild(path, sub, 2)
+	}
+
+	// 処理待ち
+	w.wg.Wait()
+	return nil
 }
 
-// 再帰的にディレクトリを取得
-func getDirRecursion(basedir, dirname string) ([]string, error) {
-	dirs := []string{dirname}
+// 子ディレクトリを走査する(空きスレッドがあれば並列、なければ同じスレッドで処理)
+func (w *Walker) walkChild(basedir, dirname string, depth int) {
+	// 除外ディレクトリはスキップ
+	if w.SkipDir != nil && w.SkipDir(dirname) {
+		return
+	}
+
+	// 別のファイルシステムはスキップ
+	if w.hasDevice {
+		dev, ok := deviceID(filepath.Join(basedir, dirname))
+		if ok && dev != w.device {
+			slog.Info("マウントポイントをスキップ", "Directory", dirname)
+			return
+		}
+	}
+
+	select {
+	case w.sem <- struct{}{}:
+		w.wg.Add(1)
+		go func() {
+			defer w.wg.Done()
+			defer func() { <-w.sem }()
+			w.walkRecursion(basedir, dirname, depth)
+		}()
+	default:
+		w.walkRecursion(basedir, dirname, depth)
+	}
+}
 
+// 再帰的にディレクトリを走査
+// (読めないディレクトリはエラーとして記録し、送信せずに他の走査を続ける)
+func (w *Walker) walkRecursion(basedir, dirname string, depth int) {
 	// 子ディレクトリ一覧を取得
 	dirpath := filepath.Join(basedir, dirname)
 	childs, err := getSubDirs(dirpath)
 	if err != nil {
-		return dirs, err
+		slog.Error("ディレクトリ取得エラー", "ERROR", err, "Directory", dirname)
+		w.addError(dirname, err)
+		return
+	}
+	if !w.visit(dirname) {
+		return
+	}
+	w.ch <- dirname
+
+	// 指定階層に達した場合は子ディレクトリを走査しない
+	if w.MaxDepth > 0 && depth >= w.MaxDepth {
+		return
 	}
 
 	// 子ディレクトリに対して再帰的にディレクトリ検索を行う
 	for _, child := range childs {
-		childDir := filepath.Join(dirname, child)
-		grands, err := getDirRecursion(basedir, childDir)
-		if err != nil {
-			return dirs, err
-		}
-		dirs = append(dirs, grands...)
+		w.walkChild(basedir, filepath.Join(dirname, child), depth+1)
 	}
-	return dirs, nil
+}
+
+// ディレクトリを読み込んだ時の処理を呼び出す(エラーの場合は走査エラーとして記録)
+func (w *Walker) visit(dirname string) bool {
+	if w.VisitDir == nil {
+		return true
+	}
+	err := w.VisitDir(dirname)
+	if err != nil {
+		slog.Error("ディレクトリ読込エラー", "ERROR", err, "Directory", dirname)
+		w.addError(dirname, err)
+		return false
+	}
+	return true
+}
+
+// 走査エラーを記録する
+func (w *Walker) addError(dirname string, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.errors = append(w.errors, DirError{Path: dirname, Err: err})
+}
+
+// 走査できなかったディレクトリ一覧を取得
+func (w *Walker) Errors() []DirError {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	errs := make([]DirError, len(w.errors))
+	copy(errs, w.errors)
+	return errs
 }
 
 // ディレクトリ一覧を取得
@@ -58,33 +185,27 @@ func getSubDirs(path string) ([]string, error) {
 	return dirs, nil
 }
 
-// ディレクトリ一覧を取得
+// ディレクトリ一覧を取得(深い階層から順に並べる)
 func GetDirs(path string) ([]string, error) {
-	dirs := []string{"."}
-
-	// 直下のディレクトリを取得
-	subDirs, err := getSubDirs(path)
-	if err != nil {
-		return dirs, err
-	}
-
-	// サブディレクトリごとに再帰的にディレクトリを取得
-	var wg sync.WaitGroup
-	ch := make(chan []string)
-	for _, entry := range subDirs {
-		wg.Add(1)
-		go getDirWorker(path, entry, &wg, ch)
-	}
+	return NewWalker(runtime.NumCPU()).GetDirs(path)
+}
 
-	// 処理待ち
+// 走査結果をすべて受け取り、降順にソートする
+func (w *Walker) GetDirs(path string) ([]string, error) {
+	dirs := []string{}
+	ch := make(chan string)
+	var err error
 	go func() {
-		wg.Wait()
+		err = w.Walk(path, ch)
 		close(ch)
 	}()
 
 	// 結果を受け取る
 	for result := range ch {
-		dirs = append(dirs, result...)
+		dirs = append(dirs, result)
+	}
+	if err != nil {
+		return []string{"."}, err
 	}
 
 	// 降順にソート
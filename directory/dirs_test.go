@@ -78,18 +78,41 @@ func TestGetSubDirs(t *testing.T) {
 	}
 }
 
-func TestGetDirRcrs(t *testing.T) {
+func TestWalk(t *testing.T) {
 	tests := recursionCase
 
 	os.RemoveAll(testDir)
 
+	// 指定ディレクトリが存在しない場合
+	t.Run("Not Exist Directory", func(t *testing.T) {
+		ch := make(chan string, 1)
+		err := NewWalker(2).Walk(testDir, ch)
+		assert.Error(t, err, "Walk", testDir)
+		assert.Len(t, ch, 0, "送信なし")
+	})
+
 	for _, tt := range tests {
 		t.Run(tt.Name, func(t *testing.T) {
 			defer os.RemoveAll(testDir)
 
 			testutil.PrepareDirs(t, tt, testDir)
-			dirs, err := getDirRecursion(testDir, tt.Name)
+			dirs := []string{}
+			ch := make(chan string)
+			done := make(chan struct{})
+			go func() {
+				for dir := range ch {
+					dirs = append(dirs, dir)
+				}
+				close(done)
+			}()
+
+			// 走査スレッド数より多いディレクトリも漏れなく送信される
+			err := NewWalker(1).Walk(testDir, ch)
+			close(ch)
+			<-done
+			tt.TestDirs = append(tt.TestDirs, ".")
 			checkDirs(t, tt, dirs, err)
+			assert.Equal(t, ".", dirs[0], "起点ディレクトリを最初に送信")
 		})
 	}
 }
@@ -107,6 +130,68 @@ func TestGetDir(t *testing.T) {
 			dirs, err := GetDirs(testDir)
 			tt.TestDirs = append(tt.TestDirs, ".")
 			checkDirs(t, tt, dirs, err)
+			for i := 1; i < len(dirs); i++ {
+				assert.Greater(t, dirs[i-1], dirs[i], "降順にソート")
+			}
+		})
+	}
+}
+
+// 読めないディレクトリがあっても他のディレクトリの走査を続ける
+func TestWalkError(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root ではパーミッションエラーを再現できない")
+	}
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	tt := testutil.TestCase{
+		TestDirs: []string{"a", "b", "b/d"},
+	}
+	testutil.PrepareDirs(t, tt, testDir)
+	denied := filepath.Join(testDir, "a")
+	err := os.MkdirAll(filepath.Join(denied, "c"), 0755)
+	require.NoError(t, err, "準備：ディレクトリ作成")
+	require.NoError(t, os.Chmod(denied, 0), "準備：パーミッション変更")
+	defer os.Chmod(denied, 0755)
+
+	walker := NewWalker(2)
+	dirs, err := walker.GetDirs(testDir)
+	require.NoError(t, err, "Walk")
+	assert.ElementsMatch(t, []string{".", "b", "b/d"}, dirs, "読めるディレクトリ")
+	errs := walker.Errors()
+	require.Len(t, errs, 1, "走査エラー")
+	assert.Equal(t, "a", errs[0].Path, "走査エラーのパス")
+}
+
+// 走査階層数の制限
+func TestWalkMaxDepth(t *testing.T) {
+	tests := []struct {
+		depth int
+		dirs  []string
+	}{
+		{0, []string{".", "a", "a/b", "a/b/c"}},
+		{1, []string{"."}},
+		{2, []string{".", "a"}},
+		{3, []string{".", "a", "a/b"}},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	tt := testutil.TestCase{
+		TestDirs: []string{"a/b/c"},
+	}
+	testutil.PrepareDirs(t, tt, testDir)
+
+	for _, tc := range tests {
+		t.Run(strconv.Itoa(tc.depth), func(t *testing.T) {
+			walker := NewWalker(2)
+			walker.MaxDepth = tc.depth
+			walker.OneFileSystem = true
+			dirs, err := walker.GetDirs(testDir)
+			require.NoError(t, err, "Walk")
+			assert.ElementsMatch(t, tc.dirs, dirs, "走査階層数 %d", tc.depth)
 		})
 	}
 }
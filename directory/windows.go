@@ -0,0 +1,37 @@
+//go:build windows
+
+package directory
+
+import (
+	"golang.org/x/sys/windows"
+)
+
+// ディレクトリのボリュームシリアル番号を取得する
+func deviceID(path string) (uint64, bool) {
+	dirPath, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, false
+	}
+
+	// ディレクトリのハンドルを取得
+	handle, err := windows.CreateFile(
+		dirPath,
+		0,
+		windows.FILE_SHARE_READ|windows.FILE_SHARE_WRITE|windows.FILE_SHARE_DELETE,
+		nil,
+		windows.OPEN_EXISTING,
+		windows.FILE_FLAG_BACKUP_SEMANTICS,
+		0,
+	)
+	if err != nil {
+		return 0, false
+	}
+	defer windows.CloseHandle(handle)
+
+	var data windows.ByHandleFileInformation
+	err = windows.GetFileInformationByHandle(handle, &data)
+	if err != nil {
+		return 0, false
+	}
+	return uint64(data.VolumeSerialNumber), true
+}
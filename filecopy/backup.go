@@ -0,0 +1,163 @@
+package filecopy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 世代管理用の日時の書式
+const BackupTimeFormat = "20060102T150405"
+
+// 上書きするコピー先ファイルのバックアップ
+//
+//	Dir が空      … コピー先と同じディレクトリに file.txt~ または file.txt.20261017T120000 として退避する
+//	Dir を指定    … Dir 以下にコピー先と同じ相対パスで退避する
+//
+// 同じ実行の中で同じファイルを2回以上退避する場合は、先のバックアップを上書きせずに
+// file.txt~.1 のように連番を付けて退避する
+type Backup struct {
+	Dir       string    // バックアップ先ディレクトリ(空の場合はコピー先と同じディレクトリ)
+	Suffix    string    // 世代管理しない場合の接尾辞(既定値は「~」)
+	Timestamp bool      // 日時を付けて世代管理する
+	Keep      int       // ファイルごとに残す世代数(0は無制限)
+	Now       time.Time // 世代管理の日時
+
+	mu    sync.Mutex
+	saved map[string]bool // この実行で退避したバックアップ
+}
+
+// バックアップ設定を作成する
+func NewBackup(dir string, timestamp bool, keep int) *Backup {
+	return &Backup{
+		Dir:       dir,
+		Suffix:    "~",
+		Timestamp: timestamp,
+		Keep:      keep,
+		Now:       time.Now(),
+	}
+}
+
+// コピー先ファイルを退避する(ファイルが存在しない場合は何もしない)
+func (b *Backup) Save(dst, relPath string) error {
+	if _, err := os.Lstat(dst); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	// 退避先を決める
+	backup := dst
+	if b.Dir != "" {
+		backup = filepath.Join(b.Dir, relPath)
+	}
+	if b.Timestamp {
+		backup += "." + b.Now.Format(BackupTimeFormat)
+	} else if b.Dir == "" {
+		backup += b.Suffix
+	}
+	name := backup
+	backup = b.reserve(name)
+
+	err := os.MkdirAll(filepath.Dir(backup), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	// 別のファイルシステムの場合はコピーしてから削除する
+	err = os.Rename(dst, backup)
+	if err != nil {
+		err = CopyFile(dst, backup)
+		if err != nil {
+			return err
+		}
+		err = os.Remove(dst)
+		if err != nil {
+			return err
+		}
+	}
+
+	if b.Timestamp && b.Keep > 0 {
+		return b.prune(name)
+	}
+	return nil
+}
+
+// 退避先のパスを確保する
+// (この実行で退避済み、または世代管理で同じ日時のバックアップが存在する場合は連番を付ける)
+func (b *Backup) reserve(name string) string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.saved == nil {
+		b.saved = map[string]bool{}
+	}
+
+	backup := name
+	for i := 1; b.saved[backup] || (b.Timestamp && exists(backup)); i++ {
+		backup = name + "." + strconv.Itoa(i)
+	}
+	b.saved[backup] = true
+	return backup
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// 保持する世代数を超えた古いバックアップを削除する
+func (b *Backup) prune(backup string) error {
+	dir := filepath.Dir(backup)
+	prefix := strings.TrimSuffix(filepath.Base(backup), b.Now.Format(BackupTimeFormat))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// 日時(同じ日時の場合は連番)が付いたファイルを世代とする
+	type version struct {
+		name string
+		time time.Time
+		seq  int
+	}
+	versions := []version{}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp, seq := strings.TrimPrefix(name, prefix), 0
+		if i := strings.IndexByte(stamp, '.'); i >= 0 {
+			n, err := strconv.Atoi(stamp[i+1:])
+			if err != nil || n <= 0 {
+				continue
+			}
+			stamp, seq = stamp[:i], n
+		}
+		t, err := time.Parse(BackupTimeFormat, stamp)
+		if err == nil {
+			versions = append(versions, version{name: name, time: t, seq: seq})
+		}
+	}
+	if len(versions) <= b.Keep {
+		return nil
+	}
+
+	// 新しい順に並べて古い世代を削除
+	sort.Slice(versions, func(i, j int) bool {
+		if !versions[i].time.Equal(versions[j].time) {
+			return versions[i].time.After(versions[j].time)
+		}
+		return versions[i].seq > versions[j].seq
+	})
+	for _, v := range versions[b.Keep:] {
+		err := os.Remove(filepath.Join(dir, v.name))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
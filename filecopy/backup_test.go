@@ -0,0 +1,101 @@
+package filecopy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBackupSave(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	dst := filepath.Join(testDir, "dst", "a", "file1.txt")
+	writeDst := func(data string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(dst), 0755))
+		require.NoError(t, os.WriteFile(dst, []byte(data), 0644))
+	}
+
+	// コピー先が存在しない場合は何もしない
+	t.Run("not_exist", func(t *testing.T) {
+		err := NewBackup("", false, 0).Save(dst, "a/file1.txt")
+		assert.NoError(t, err, "Save")
+	})
+
+	// 同じディレクトリに「~」を付けて退避
+	t.Run("suffix", func(t *testing.T) {
+		writeDst("old")
+		err := NewBackup("", false, 0).Save(dst, "a/file1.txt")
+		require.NoError(t, err, "Save")
+		assert.NoFileExists(t, dst, "退避元")
+		data, err := os.ReadFile(dst + "~")
+		require.NoError(t, err, "ReadFile")
+		assert.Equal(t, "old", string(data), "退避先")
+	})
+
+	// 別ディレクトリに同じ相対パスで退避
+	t.Run("dir", func(t *testing.T) {
+		writeDst("old")
+		backupDir := filepath.Join(testDir, "backup")
+		err := NewBackup(backupDir, false, 0).Save(dst, "a/file1.txt")
+		require.NoError(t, err, "Save")
+		assert.NoFileExists(t, dst, "退避元")
+		assert.FileExists(t, filepath.Join(backupDir, "a", "file1.txt"), "退避先")
+	})
+
+	// 日時を付けて世代管理(古い世代を削除)
+	t.Run("timestamp", func(t *testing.T) {
+		base := time.Date(2026, 10, 17, 12, 0, 0, 0, time.Local)
+		for i := 0; i < 4; i++ {
+			writeDst("old")
+			backup := NewBackup("", true, 2)
+			backup.Now = base.Add(time.Duration(i) * time.Hour)
+			err := backup.Save(dst, "a/file1.txt")
+			require.NoError(t, err, "Save")
+		}
+		assert.NoFileExists(t, dst+".20261017T120000", "削除された世代")
+		assert.NoFileExists(t, dst+".20261017T130000", "削除された世代")
+		assert.FileExists(t, dst+".20261017T140000", "残す世代")
+		assert.FileExists(t, dst+".20261017T150000", "残す世代")
+		assert.FileExists(t, dst+"~", "世代管理対象外のファイル")
+	})
+	// 同じ実行で2回退避する場合は先のバックアップを上書きしない
+	t.Run("same_run", func(t *testing.T) {
+		os.RemoveAll(testDir)
+		backup := NewBackup("", false, 0)
+		for _, data := range []string{"first", "second", "third"} {
+			writeDst(data)
+			require.NoError(t, backup.Save(dst, "a/file1.txt"), "Save")
+		}
+		for name, want := range map[string]string{"~": "first", "~.1": "second", "~.2": "third"} {
+			data, err := os.ReadFile(dst + name)
+			require.NoError(t, err, "ReadFile")
+			assert.Equal(t, want, string(data), name)
+		}
+
+		// 別の実行では前回のバックアップを置き換える
+		writeDst("next")
+		require.NoError(t, NewBackup("", false, 0).Save(dst, "a/file1.txt"), "Save")
+		data, err := os.ReadFile(dst + "~")
+		require.NoError(t, err, "ReadFile")
+		assert.Equal(t, "next", string(data), "次の実行")
+	})
+
+	// 世代管理で同じ日時のバックアップは連番を付けて退避し、世代として数える
+	t.Run("timestamp_same_run", func(t *testing.T) {
+		os.RemoveAll(testDir)
+		backup := NewBackup("", true, 2)
+		backup.Now = time.Date(2026, 10, 17, 12, 0, 0, 0, time.Local)
+		for i := 0; i < 3; i++ {
+			writeDst("old")
+			require.NoError(t, backup.Save(dst, "a/file1.txt"), "Save")
+		}
+		assert.NoFileExists(t, dst+".20261017T120000", "削除された世代")
+		assert.FileExists(t, dst+".20261017T120000.1", "残す世代")
+		assert.FileExists(t, dst+".20261017T120000.2", "残す世代")
+	})
+}
@@ -4,44 +4,73 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 )
 
 // Config.TargetFilesに一致するファイルかチェックする
+// ('/' を含まないパターンはファイル名のみで比較する、繰り返しチェックする場合は NewMatcher を使用する)
 func IsCopyFile(srcFile string, targetFiles []string) bool {
-	// ファイル名のチェック対象
-	srcFile = filepath.Base(srcFile)
-	for _, pattern := range targetFiles {
-		matched, _ := filepath.Match(pattern, srcFile)
-		//fmt.Println(pattern, srcFile, matched)
-		if matched {
-			return true
-		}
+	matcher, err := NewMatcher(targetFiles)
+	if err != nil {
+		return false
 	}
-	return false
+	return matcher.Match(srcFile)
 }
 
 // ファイルの差分をチェックする(サイズ、更新日付)
 func IsFileDiff(src, dst string) bool {
+	return CompareFile(src, dst) != DiffSame
+}
+
+// コピー元とコピー先の比較結果
+type FileDiff int
+
+const (
+	DiffUnknown FileDiff = iota // コピー元の情報が取得できない
+	DiffMissing                 // コピー先が存在しない
+	DiffSame                    // 差分なし
+	DiffNewer                   // コピー元の方が新しい
+	DiffOlder                   // コピー元の方が古い
+	DiffChanged                 // 更新日時が同じでサイズが異なる
+)
+
+// コピー元とコピー先のファイルを比較する(サイズ、更新日付)
+func CompareFile(src, dst string) FileDiff {
 	// 元ファイルの情報取得
 	srcInfo, err := os.Stat(src)
 	if err != nil {
-		return true
+		return DiffUnknown
 	}
 
+	return CompareInfo(srcInfo.Size(), srcInfo.ModTime(), dst)
+}
+
+// コピー元のサイズ、更新日時とコピー先のファイルを比較する(アーカイブのエントリなど)
+func CompareInfo(size int64, modTime time.Time, dst string) FileDiff {
 	// コピー先のファイルの情報取得
 	dstInfo, err := os.Stat(dst)
 	if err != nil {
-		return true
+		return DiffMissing
 	}
+	return CompareFileInfo(size, modTime, dstInfo)
+}
 
+// コピー元のサイズ、更新日時とコピー先のファイル情報を比較する
+// (コピー先の方が新しい場合は、更新日時の精度が粗いファイルシステムでも差分なしとする)
+func CompareFileInfo(size int64, modTime time.Time, dstInfo os.FileInfo) FileDiff {
 	// ファイルのサイズと更新日を比較
-	if dstInfo.Size() == srcInfo.Size() {
-		if dstInfo.ModTime().UnixNano() >= srcInfo.ModTime().UnixNano() {
-			return false
-		}
+	srcTime := modTime.UnixNano()
+	dstTime := dstInfo.ModTime().UnixNano()
+	switch {
+	case dstInfo.Size() == size && dstTime >= srcTime:
+		return DiffSame
+	case srcTime > dstTime:
+		return DiffNewer
+	case srcTime < dstTime:
+		return DiffOlder
+	default:
+		return DiffChanged
 	}
-
-	return true
 }
 
 // ファイルコピーし、更新日時等変更する
@@ -53,8 +82,24 @@ func CopyFile(src, dst string) error {
 	return copyTimestamps(src, dst)
 }
 
-// ファイルコピー
-func copyData(src, dst string) error {
+// 読み込んだデータをファイルに書き込み、更新日時、パーミッションを設定する(アーカイブのエントリなど)
+// (perm が0の場合はパーミッションを変更しない)
+func CopyReader(r io.Reader, dst string, modTime time.Time, perm os.FileMode) error {
+	err := writeFile(r, dst)
+	if err != nil {
+		return err
+	}
+	if perm != 0 {
+		err = os.Chmod(dst, perm)
+		if err != nil {
+			return err
+		}
+	}
+	return os.Chtimes(dst, modTime, modTime)
+}
+
+// ハードリンクを作成する(コピー先が存在する場合は置き換える)
+func LinkFile(src, dst string) error {
 	// 出力先ディレクトリを作成
 	dstDir := filepath.Dir(dst)
 	err := os.MkdirAll(dstDir, os.ModePerm)
@@ -62,12 +107,32 @@ func copyData(src, dst string) error {
 		return err
 	}
 
+	err = os.Remove(dst)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Link(src, dst)
+}
+
+// ファイルコピー
+func copyData(src, dst string) error {
 	// 元ファイルを開く
 	srcFile, err := os.Open(src)
 	if err != nil {
 		return err
 	}
 	defer srcFile.Close()
+	return writeFile(srcFile, dst)
+}
+
+// 読み込んだデータをファイルに書き込む
+func writeFile(r io.Reader, dst string) error {
+	// 出力先ディレクトリを作成
+	dstDir := filepath.Dir(dst)
+	err := os.MkdirAll(dstDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
 
 	// コピー先ファイルを作成
 	dstFile, err := os.Create(dst)
@@ -77,7 +142,7 @@ func copyData(src, dst string) error {
 	defer dstFile.Close()
 
 	// データをコピー
-	_, err = io.Copy(dstFile, srcFile)
+	_, err = io.Copy(dstFile, r)
 	if err != nil {
 		return err
 	}
@@ -107,3 +107,95 @@ func TestIsFileDiff(t *testing.T) {
 	// fmt.Println(fileInfo3.ModTime().UnixNano(), fileInfo3.Name(), fileInfo3.Size())
 	// fmt.Println(fileInfo4.ModTime().UnixNano(), fileInfo4.Name(), fileInfo4.Size())
 }
+
+func TestCompareFile(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// 準備
+	src := filepath.Join(testDir, "src")
+	dst := filepath.Join(testDir, "dst")
+	err := os.MkdirAll(testDir, 0755)
+	require.NoError(t, err, "準備：ディレクトリ作成 %s", testDir)
+	require.NoError(t, os.WriteFile(src, []byte("12345"), 0644))
+	base := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(src, base, base))
+
+	tests := []struct {
+		name    string
+		data    string
+		modTime time.Time
+		diff    FileDiff
+	}{
+		{"same", "12345", base, DiffSame},
+		{"dst_newer_same_size", "12345", base.Add(time.Minute), DiffSame},
+		{"newer", "1234", base.Add(-time.Minute), DiffNewer},
+		{"older", "1234", base.Add(time.Minute), DiffOlder},
+		{"changed", "1234", base, DiffChanged},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, os.WriteFile(dst, []byte(tt.data), 0644))
+			require.NoError(t, os.Chtimes(dst, tt.modTime, tt.modTime))
+			assert.Equal(t, tt.diff, CompareFile(src, dst), "CompareFile")
+		})
+	}
+
+	assert.Equal(t, DiffMissing, CompareFile(src, "aaa"), "dst file is not exist")
+	assert.Equal(t, DiffUnknown, CompareFile("aaa", dst), "src file is not exist")
+}
+
+func TestHashFile(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	err := os.MkdirAll(testDir, 0755)
+	require.NoError(t, err, "準備：ディレクトリ作成 %s", testDir)
+	file := filepath.Join(testDir, "file1")
+	require.NoError(t, os.WriteFile(file, []byte("abc"), 0644))
+
+	hash, err := HashFile(file)
+	require.NoError(t, err, "HashFile")
+	assert.Equal(t, "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad", hash, "sha256")
+
+	_, err = HashFile("aaa")
+	assert.Error(t, err, "file is not exist")
+}
+
+func TestCopyFileMulti(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// 複数チャンクになるサイズのファイル
+	src := filepath.Join(testDir, "src", "file1")
+	require.NoError(t, os.MkdirAll(filepath.Dir(src), 0755))
+	data := make([]byte, multiChunkSize*2+123)
+	for i := range data {
+		data[i] = byte(i % 251)
+	}
+	require.NoError(t, os.WriteFile(src, data, 0644))
+	mtime := time.Now().Add(-time.Hour)
+	require.NoError(t, os.Chtimes(src, mtime, mtime))
+
+	// 書き込めないコピー先があっても他のコピー先には書き込まれること
+	blocker := filepath.Join(testDir, "blocker")
+	require.NoError(t, os.WriteFile(blocker, nil, 0644))
+	dsts := []string{
+		filepath.Join(testDir, "dst1", "file1"),
+		filepath.Join(blocker, "file1"),
+		filepath.Join(testDir, "dst2", "sub", "file1"),
+	}
+	errs, err := CopyFileMulti(src, dsts)
+	require.NoError(t, err, "CopyFileMulti")
+	assert.NoError(t, errs[0], dsts[0])
+	assert.Error(t, errs[1], dsts[1])
+	assert.NoError(t, errs[2], dsts[2])
+	for _, dst := range []string{dsts[0], dsts[2]} {
+		assert.Equal(t, DiffSame, CompareFile(src, dst), dst)
+		copied, _ := os.ReadFile(dst)
+		assert.Equal(t, data, copied, dst)
+	}
+
+	_, err = CopyFileMulti(filepath.Join(testDir, "aaa"), dsts)
+	assert.Error(t, err, "file is not exist")
+}
@@ -4,8 +4,20 @@ package filecopy
 
 import (
 	"os"
+	"syscall"
 )
 
+// シンボリックリンクを開かない
+const oNoFollow = syscall.O_NOFOLLOW
+
+// ファイルのハードリンク数を取得する(取得できない場合は1)
+func linkCount(path string, info os.FileInfo) uint64 {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return uint64(stat.Nlink)
+	}
+	return 1
+}
+
 // 作成日時、更新日時をコピーする
 func copyTimestamps(src, dst string) error {
 	srcInfo, err := os.Stat(src)
@@ -0,0 +1,105 @@
+package filecopy
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// 一度に読み込むサイズ
+const multiChunkSize = 1 << 20
+
+// 1つのファイルを一度だけ読み込み、複数のコピー先に並行して書き込む
+// (コピー元を読み込めない場合は err、コピー先ごとのエラーは errs に返す)
+func CopyFileMulti(src string, dsts []string) (errs []error, err error) {
+	errs = make([]error, len(dsts))
+
+	// 元ファイルを開く
+	srcFile, err := os.Open(src)
+	if err != nil {
+		return errs, err
+	}
+	defer srcFile.Close()
+
+	// コピー先ごとに書き込む(書き込みが遅いコピー先を待つのは読み込んだ数チャンクまで)
+	chs := make([]chan []byte, len(dsts))
+	var wg sync.WaitGroup
+	for i, dst := range dsts {
+		chs[i] = make(chan []byte, 4)
+		wg.Add(1)
+		go func(i int, dst string) {
+			defer wg.Done()
+			errs[i] = writeChunks(dst, chs[i])
+		}(i, dst)
+	}
+
+	// 読み込んだデータをすべてのコピー先に送る
+	var readErr error
+	for {
+		buf := make([]byte, multiChunkSize)
+		n, err := io.ReadFull(srcFile, buf)
+		if n > 0 {
+			for _, ch := range chs {
+				ch <- buf[:n]
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+	for _, ch := range chs {
+		close(ch)
+	}
+	wg.Wait()
+	if readErr != nil {
+		return errs, readErr
+	}
+
+	// 更新日時をコピー
+	for i, dst := range dsts {
+		if errs[i] == nil {
+			errs[i] = copyTimestamps(src, dst)
+		}
+	}
+	return errs, nil
+}
+
+// 受け取ったデータをファイルに書き込む(エラーの場合も残りのデータは読み捨てる)
+func writeChunks(dst string, ch <-chan []byte) error {
+	defer func() {
+		for range ch {
+		}
+	}()
+
+	// 出力先ディレクトリを作成
+	err := os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	// コピー先ファイルを作成
+	dstFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer dstFile.Close()
+
+	for buf := range ch {
+		_, err = dstFile.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+
+	// ファイルのバッファをフラッシュ
+	err = dstFile.Sync()
+	if err != nil {
+		return err
+	}
+	return dstFile.Close()
+}
@@ -0,0 +1,238 @@
+package filecopy
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ファイル名のパターン
+//
+//	*.txt          … ファイル名(ベース名)に一致
+//	src/*/conf.yml … '/' を含む場合はコピー元からの相対パスに一致
+//	/build         … 先頭の '/' はコピー元直下に固定
+//	logs/**/*.gz   … '**' は0個以上のディレクトリに一致
+//	!keep.txt      … 先頭の '!' は一致したファイルを対象から外す
+type Pattern struct {
+	Negate   bool // '!' で始まるパターン
+	DirOnly  bool // '/' で終わるパターン(ディレクトリのみに一致)
+	anchored bool
+	segments []string
+}
+
+// パターンを解析する
+func CompilePattern(pattern string) (*Pattern, error) {
+	p := &Pattern{}
+	if strings.HasPrefix(pattern, "!") {
+		p.Negate = true
+		pattern = pattern[1:]
+	}
+	if len(pattern) > 1 && strings.HasSuffix(pattern, "/") {
+		p.DirOnly = true
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	// '/' を含む場合はコピー元からの相対パスに固定する
+	pattern = filepath.ToSlash(pattern)
+	if strings.Contains(pattern, "/") {
+		p.anchored = true
+		pattern = strings.TrimPrefix(pattern, "/")
+	}
+
+	for _, seg := range strings.Split(pattern, "/") {
+		// '**' 以外の '**' は '*' と同じ扱い
+		if seg != "**" {
+			for strings.Contains(seg, "**") {
+				seg = strings.ReplaceAll(seg, "**", "*")
+			}
+		}
+		// [!a-z] を [^a-z] として扱う
+		seg = strings.ReplaceAll(seg, "[!", "[^")
+		if _, err := path.Match(seg, ""); err != nil {
+			return nil, err
+		}
+		p.segments = append(p.segments, seg)
+	}
+	return p, nil
+}
+
+// 相対パスがパターンに一致するかチェックする(Negateは考慮しない)
+func (p *Pattern) Match(relPath string) bool {
+	names := strings.Split(filepath.ToSlash(relPath), "/")
+	if !p.anchored {
+		// ファイル名のみで比較する
+		names = names[len(names)-1:]
+	}
+	return matchSegments(p.segments, names)
+}
+
+// パス要素ごとに比較する('**' は0個以上の要素に一致)
+func matchSegments(segments, names []string) bool {
+	for len(segments) > 0 {
+		seg := segments[0]
+		if seg == "**" {
+			// 残りのパス要素のいずれかから一致すればよい
+			for i := 0; i <= len(names); i++ {
+				if matchSegments(segments[1:], names[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(names) == 0 {
+			return false
+		}
+		matched, _ := path.Match(seg, names[0])
+		if !matched {
+			return false
+		}
+		segments = segments[1:]
+		names = names[1:]
+	}
+	return len(names) == 0
+}
+
+// 複数パターンの判定(後に書かれたパターンを優先する)
+type Matcher struct {
+	patterns []*Pattern
+}
+
+// パターン一覧を解析する
+func NewMatcher(patterns []string) (*Matcher, error) {
+	m := &Matcher{}
+	for _, pattern := range patterns {
+		p, err := CompilePattern(pattern)
+		if err != nil {
+			return nil, err
+		}
+		m.patterns = append(m.patterns, p)
+	}
+	return m, nil
+}
+
+// ファイルの相対パスがパターンに一致するかチェックする
+func (m *Matcher) Match(relPath string) bool {
+	return m.match(relPath, false)
+}
+
+// ディレクトリの相対パスがパターンに一致するかチェックする
+func (m *Matcher) MatchDir(relPath string) bool {
+	return m.match(relPath, true)
+}
+
+func (m *Matcher) match(relPath string, isDir bool) bool {
+	matched := false
+	for _, p := range m.patterns {
+		if p.DirOnly && !isDir {
+			continue
+		}
+		if p.Match(relPath) {
+			matched = !p.Negate
+		}
+	}
+	return matched
+}
+
+// コピー対象ファイルの判定(対象パターンと除外パターン)
+type Filter struct {
+	MinSize int64         // このサイズ(バイト)より小さいファイルを除外する(0は無制限)
+	MaxSize int64         // このサイズ(バイト)より大きいファイルを除外する(0は無制限)
+	MinAge  time.Duration // 更新からこの期間が経っていないファイルを除外する(0は無制限)
+	MaxAge  time.Duration // 更新からこの期間より古いファイルを除外する(0は無制限)
+	Now     time.Time     // 経過期間の基準日時
+
+	include    *Matcher
+	exclude    *Matcher
+	excludeDir *Matcher
+	rules      Rules
+	ignore     *dirRules
+}
+
+// 対象ファイル、除外ファイル、除外ディレクトリのパターンから判定を作成する
+func NewFilter(include, exclude, excludeDirs []string) (*Filter, error) {
+	inc, err := NewMatcher(include)
+	if err != nil {
+		return nil, err
+	}
+	exc, err := NewMatcher(exclude)
+	if err != nil {
+		return nil, err
+	}
+	excDir, err := NewMatcher(excludeDirs)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{Now: time.Now(), include: inc, exclude: exc, excludeDir: excDir}, nil
+}
+
+// ルールを追加する(後から追加したルールを優先する)
+func (f *Filter) AddRules(rules *Rules) {
+	f.rules.rules = append(f.rules.rules, rules.rules...)
+}
+
+// ディレクトリごとのルールファイル(.mechaignore等)を使用する
+func (f *Filter) UseIgnoreFile(baseDir, name string) {
+	f.ignore = &dirRules{baseDir: baseDir, name: name}
+}
+
+// ディレクトリのルールファイルを読み込む(ディレクトリ走査時に呼び出す)
+func (f *Filter) LoadDir(dirname string) error {
+	if f.ignore == nil {
+		return nil
+	}
+	return f.ignore.load(dirname)
+}
+
+// コピー元からの相対パスでコピー対象のファイルかチェックする
+func (f *Filter) IsCopyFile(relPath string) bool {
+	if !f.include.Match(relPath) || f.exclude.Match(relPath) {
+		return false
+	}
+	return !f.isExcludeRule(relPath, false)
+}
+
+// コピー元からの相対パスで除外するディレクトリかチェックする
+func (f *Filter) IsExcludeDir(relPath string) bool {
+	if f.excludeDir.MatchDir(relPath) {
+		return true
+	}
+	return f.isExcludeRule(relPath, true)
+}
+
+// ルール(ルールファイル、ディレクトリごとのルールファイルの順に優先)で除外するかチェックする
+func (f *Filter) isExcludeRule(relPath string, isDir bool) bool {
+	excluded := false
+	if include, ok := f.rules.Match(relPath, isDir); ok {
+		excluded = !include
+	}
+	if f.ignore != nil {
+		if include, ok := f.ignore.match(relPath, isDir); ok {
+			excluded = !include
+		}
+	}
+	return excluded
+}
+
+// サイズが対象範囲内かチェックする
+func (f *Filter) IsTargetSize(size int64) bool {
+	if f.MinSize > 0 && size < f.MinSize {
+		return false
+	}
+	if f.MaxSize > 0 && size > f.MaxSize {
+		return false
+	}
+	return true
+}
+
+// 更新日時が対象範囲内かチェックする
+func (f *Filter) IsTargetAge(modTime time.Time) bool {
+	age := f.Now.Sub(modTime)
+	if f.MinAge > 0 && age < f.MinAge {
+		return false
+	}
+	if f.MaxAge > 0 && age > f.MaxAge {
+		return false
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package filecopy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatternMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		matched bool
+	}{
+		{"*.txt", "file1.txt", true},
+		{"*.txt", "a/b/file1.txt", true},
+		{"*.txt", "a/b/file1.log", false},
+		{"file[0-9].txt", "a/file1.txt", true},
+		{"file[!0-9].txt", "a/file1.txt", false},
+		{"file[!0-9].txt", "a/fileA.txt", true},
+		{"src/*/config.yaml", "src/app/config.yaml", true},
+		{"src/*/config.yaml", "src/app/sub/config.yaml", false},
+		{"src/*/config.yaml", "x/src/app/config.yaml", false},
+		{"/config.yaml", "config.yaml", true},
+		{"/config.yaml", "a/config.yaml", false},
+		{"logs/**/*.gz", "logs/a.gz", true},
+		{"logs/**/*.gz", "logs/2026/10/a.gz", true},
+		{"logs/**/*.gz", "logs/2026/10/a.txt", false},
+		{"logs/**/*.gz", "old/logs/a.gz", false},
+		{"**/logs/*.gz", "old/logs/a.gz", true},
+		{"logs/**", "logs/a/b", true},
+		{"a**b.txt", "aXYb.txt", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.name, func(t *testing.T) {
+			p, err := CompilePattern(tt.pattern)
+			require.NoError(t, err, "CompilePattern %s", tt.pattern)
+			assert.Equal(t, tt.matched, p.Match(tt.name), "Match %s %s", tt.pattern, tt.name)
+		})
+	}
+
+	// 不正なパターン
+	_, err := CompilePattern("[a-")
+	assert.Error(t, err, "CompilePattern")
+}
+
+func TestFilter(t *testing.T) {
+	filter, err := NewFilter(
+		[]string{"*.txt", "!secret*.txt", "docs/**"},
+		[]string{"tmp/**/*.txt"},
+		[]string{"node_modules", "/build"},
+	)
+	require.NoError(t, err, "NewFilter")
+
+	tests := []struct {
+		name    string
+		matched bool
+	}{
+		{"file1.txt", true},
+		{"a/file1.txt", true},
+		{"a/secret1.txt", false},
+		{"file1.log", false},
+		{"docs/file1.log", true},
+		{"tmp/a/file1.txt", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.matched, filter.IsCopyFile(tt.name), "IsCopyFile %s", tt.name)
+	}
+
+	assert.True(t, filter.IsExcludeDir("node_modules"), "IsExcludeDir")
+	assert.True(t, filter.IsExcludeDir("a/node_modules"), "IsExcludeDir")
+	assert.True(t, filter.IsExcludeDir("build"), "IsExcludeDir")
+	assert.False(t, filter.IsExcludeDir("a/build"), "IsExcludeDir")
+}
+
+func TestFilterSizeAge(t *testing.T) {
+	filter, err := NewFilter([]string{"*"}, nil, nil)
+	require.NoError(t, err, "NewFilter")
+	now := filter.Now
+
+	// 未指定の場合はすべて対象
+	assert.True(t, filter.IsTargetSize(0), "IsTargetSize")
+	assert.True(t, filter.IsTargetAge(now.AddDate(-10, 0, 0)), "IsTargetAge")
+
+	filter.MinSize = 10
+	filter.MaxSize = 100
+	assert.False(t, filter.IsTargetSize(9), "最小サイズ未満")
+	assert.True(t, filter.IsTargetSize(10), "最小サイズ")
+	assert.True(t, filter.IsTargetSize(100), "最大サイズ")
+	assert.False(t, filter.IsTargetSize(101), "最大サイズ超過")
+
+	filter.MinAge = 24 * time.Hour
+	filter.MaxAge = 30 * 24 * time.Hour
+	assert.False(t, filter.IsTargetAge(now.Add(-time.Hour)), "新しいファイル")
+	assert.True(t, filter.IsTargetAge(now.AddDate(0, 0, -10)), "対象期間内")
+	assert.False(t, filter.IsTargetAge(now.AddDate(0, 0, -31)), "古いファイル")
+}
+
+func TestIsCopyFilePattern(t *testing.T) {
+	targets := []string{"*.txt", "docs/**"}
+	assert.True(t, IsCopyFile("a/b.txt", targets), "ファイル名のパターン")
+	assert.True(t, IsCopyFile("docs/x/y.md", targets), "相対パスのパターン")
+	assert.False(t, IsCopyFile("a/b.md", targets), "一致しない")
+
+	// 不正なパターンは一致しない
+	assert.False(t, IsCopyFile("a", []string{"["}), "不正なパターン")
+}
@@ -0,0 +1,163 @@
+package filecopy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// 対象/除外のルール
+type Rule struct {
+	Include bool
+	Pattern *Pattern
+}
+
+// 順序付きのルール一覧(後に書かれたルールを優先する)
+type Rules struct {
+	rules []Rule
+}
+
+// ルールファイルを読み込む
+func LoadRules(file string) (*Rules, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	rules, err := ParseRules(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return rules, nil
+}
+
+// ルールを解析する
+//
+// gitignore 形式(1行1パターン、'!' で再度対象にする)と
+// rsync フィルタ形式("+ パターン"、"- パターン"、先に書かれたルールを優先)に対応する。
+// '#' で始まる行と空行は無視する。
+func ParseRules(r io.Reader) (*Rules, error) {
+	rules := &Rules{}
+	rsync := false
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// rsync フィルタ形式
+		include, pattern, ok := parseRsyncRule(line)
+		if ok {
+			rsync = true
+		} else {
+			// gitignore 形式('\' は先頭の '#'、'!' をエスケープする)
+			include = false
+			pattern = line
+			if strings.HasPrefix(pattern, "\\#") || strings.HasPrefix(pattern, "\\!") {
+				pattern = pattern[1:]
+			} else if strings.HasPrefix(pattern, "!") {
+				include = true
+				pattern = pattern[1:]
+			}
+		}
+
+		p, err := CompilePattern(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		rules.rules = append(rules.rules, Rule{Include: include, Pattern: p})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	// rsync は先に一致したルールを優先するため、逆順にして後優先にそろえる
+	if rsync {
+		for i, j := 0, len(rules.rules)-1; i < j; i, j = i+1, j-1 {
+			rules.rules[i], rules.rules[j] = rules.rules[j], rules.rules[i]
+		}
+	}
+	return rules, nil
+}
+
+// rsync フィルタ形式の1行を解析する
+func parseRsyncRule(line string) (bool, string, bool) {
+	for _, prefix := range []string{"+ ", "include "} {
+		if strings.HasPrefix(line, prefix) {
+			return true, strings.TrimPrefix(line, prefix), true
+		}
+	}
+	for _, prefix := range []string{"- ", "exclude "} {
+		if strings.HasPrefix(line, prefix) {
+			return false, strings.TrimPrefix(line, prefix), true
+		}
+	}
+	return false, "", false
+}
+
+// ルールに一致するかチェックする(一致しない場合はmatchedがfalse)
+func (r *Rules) Match(relPath string, isDir bool) (include bool, matched bool) {
+	for i := len(r.rules) - 1; i >= 0; i-- {
+		rule := r.rules[i]
+		if rule.Pattern.DirOnly && !isDir {
+			continue
+		}
+		if rule.Pattern.Match(relPath) {
+			return rule.Include != rule.Pattern.Negate, true
+		}
+	}
+	return false, false
+}
+
+// ディレクトリごとのルールファイル(.mechaignore)
+type dirRules struct {
+	baseDir string
+	name    string
+	rules   sync.Map // ディレクトリの相対パス -> *Rules (ファイルがない場合はnil)
+}
+
+// ディレクトリのルールファイルを読み込む
+func (d *dirRules) load(dirname string) error {
+	file := filepath.Join(d.baseDir, dirname, d.name)
+	rules, err := LoadRules(file)
+	if errors.Is(err, os.ErrNotExist) {
+		d.rules.Store(filepath.Clean(dirname), (*Rules)(nil))
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	d.rules.Store(filepath.Clean(dirname), rules)
+	return nil
+}
+
+// 上位ディレクトリから順にルールを適用する(深いディレクトリのルールを優先)
+func (d *dirRules) match(relPath string, isDir bool) (bool, bool) {
+	relPath = filepath.Clean(relPath)
+	include, matched := false, false
+	dirs := []string{"."}
+	parent := filepath.Dir(relPath)
+	if parent != "." {
+		names := strings.Split(filepath.ToSlash(parent), "/")
+		for i := range names {
+			dirs = append(dirs, filepath.Join(names[:i+1]...))
+		}
+	}
+	for _, dir := range dirs {
+		value, ok := d.rules.Load(dir)
+		if !ok || value.(*Rules) == nil {
+			continue
+		}
+		name, _ := filepath.Rel(dir, relPath)
+		if inc, ok := value.(*Rules).Match(name, isDir); ok {
+			include, matched = inc, true
+		}
+	}
+	return include, matched
+}
@@ -0,0 +1,96 @@
+package filecopy
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules string
+		files map[string]bool // ファイル -> 除外するか
+	}{
+		{
+			name:  "gitignore",
+			rules: "# comment\n\n*.log\n!keep.log\nbuild/\n\\#hash.txt\n",
+			files: map[string]bool{
+				"a.log":      true,
+				"a/keep.log": false,
+				"a.txt":      false,
+				"#hash.txt":  true,
+			},
+		},
+		{
+			name:  "rsync",
+			rules: "+ keep.log\n- *.log\ninclude important/**\nexclude *.tmp\n",
+			files: map[string]bool{
+				"a.log":              true,
+				"a/keep.log":         false,
+				"important/data.tmp": false,
+				"a.tmp":              true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseRules(strings.NewReader(tt.rules))
+			require.NoError(t, err, "ParseRules")
+			for file, excluded := range tt.files {
+				include, matched := rules.Match(file, false)
+				assert.Equal(t, excluded, matched && !include, "除外 %s", file)
+			}
+		})
+	}
+
+	// ディレクトリのみに一致するルール
+	rules, err := ParseRules(strings.NewReader("build/\n"))
+	require.NoError(t, err, "ParseRules")
+	_, matched := rules.Match("a/build", false)
+	assert.False(t, matched, "ファイルには一致しない")
+	include, matched := rules.Match("a/build", true)
+	assert.True(t, matched && !include, "ディレクトリは除外")
+
+	// 不正なパターン
+	_, err = ParseRules(strings.NewReader("*.log\n[a-\n"))
+	assert.ErrorContains(t, err, "line 2", "不正なパターン")
+}
+
+func TestFilterIgnoreFile(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// 準備
+	err := os.MkdirAll(filepath.Join(testDir, "a", "b"), 0755)
+	require.NoError(t, err, "準備：ディレクトリ作成")
+	err = os.WriteFile(filepath.Join(testDir, ".mechaignore"), []byte("*.log\ntmp/\n"), 0644)
+	require.NoError(t, err, "準備：ファイル作成")
+	err = os.WriteFile(filepath.Join(testDir, "a", ".mechaignore"), []byte("!keep.log\n/b\n"), 0644)
+	require.NoError(t, err, "準備：ファイル作成")
+
+	filter, err := NewFilter([]string{"*"}, nil, nil)
+	require.NoError(t, err, "NewFilter")
+	rules, err := ParseRules(strings.NewReader("*.bak\n"))
+	require.NoError(t, err, "ParseRules")
+	filter.AddRules(rules)
+	filter.UseIgnoreFile(testDir, ".mechaignore")
+	for _, dir := range []string{".", "a", "a/b"} {
+		require.NoError(t, filter.LoadDir(dir), "LoadDir %s", dir)
+	}
+
+	assert.False(t, filter.IsCopyFile("x.log"), "ルートのルール")
+	assert.False(t, filter.IsCopyFile("a/x.log"), "上位ディレクトリのルール")
+	assert.True(t, filter.IsCopyFile("a/keep.log"), "深いディレクトリのルールを優先")
+	assert.False(t, filter.IsCopyFile("keep.log"), "下位ディレクトリのルールは適用しない")
+	assert.False(t, filter.IsCopyFile("a/x.bak"), "ルールファイル")
+	assert.True(t, filter.IsCopyFile("a/x.txt"), "対象ファイル")
+	assert.True(t, filter.IsExcludeDir("a/tmp"), "除外ディレクトリ")
+	assert.True(t, filter.IsExcludeDir("a/b"), "ディレクトリからの相対パス")
+	assert.False(t, filter.IsExcludeDir("b"), "ディレクトリからの相対パス")
+}
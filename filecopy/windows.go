@@ -3,6 +3,7 @@
 package filecopy
 
 import (
+	"os"
 	"unsafe"
 
 	"golang.org/x/sys/windows"
@@ -50,3 +51,22 @@ func copyTimestamps(src, dst string) error {
 
 	return nil
 }
+
+// シンボリックリンクを開かないフラグはない(Lstatで確認する)
+const oNoFollow = 0
+
+// ファイルのハードリンク数を取得する(取得できない場合は1)
+func linkCount(path string, info os.FileInfo) uint64 {
+	f, err := os.Open(path)
+	if err != nil {
+		return 1
+	}
+	defer f.Close()
+
+	var data windows.ByHandleFileInformation
+	err = windows.GetFileInformationByHandle(windows.Handle(f.Fd()), &data)
+	if err != nil {
+		return 1
+	}
+	return uint64(data.NumberOfLinks)
+}
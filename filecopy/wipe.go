@@ -0,0 +1,117 @@
+package filecopy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// 他の名前からも参照されているため上書きしない(上書きすると他の名前の内容も消える)
+var ErrHardLinked = errors.New("ハードリンクされているため上書きできません")
+
+// 上書きに使用するバッファサイズ
+const wipeBufferSize = 1 << 20
+
+// 削除前にファイルの内容を上書きする設定
+type Wipe struct {
+	Passes int  // 上書き回数
+	Random bool // 乱数で上書きする(falseの場合は0で上書き)
+}
+
+// ファイルの内容を上書きしてから削除する
+// (上書き、フラッシュ、切り詰め、ランダムな名前への変更の後に削除する)
+// シンボリックリンクはリンク先を上書きせずにリンクのみ削除する
+func (w Wipe) Remove(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return os.Remove(path)
+	case !info.Mode().IsRegular():
+		return fmt.Errorf("通常のファイルではないため上書きできません: %s", path)
+	case linkCount(path, info) > 1:
+		return fmt.Errorf("%w: %s", ErrHardLinked, path)
+	}
+
+	err = w.overwrite(path)
+	if err != nil {
+		return err
+	}
+
+	// ファイル名を推測できないように変更する
+	name := make([]byte, 16)
+	_, err = rand.Read(name)
+	if err != nil {
+		return err
+	}
+	renamed := filepath.Join(filepath.Dir(path), hex.EncodeToString(name))
+	err = os.Rename(path, renamed)
+	if err != nil {
+		return err
+	}
+	return os.Remove(renamed)
+}
+
+// ファイルの内容を上書きし、サイズを0にする(シンボリックリンクは開かない)
+func (w Wipe) overwrite(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|oNoFollow, 0)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("通常のファイルではないため上書きできません: %s", path)
+	}
+
+	passes := w.Passes
+	if passes < 1 {
+		passes = 1
+	}
+	buf := make([]byte, wipeBufferSize)
+	for i := 0; i < passes; i++ {
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+		for remain := info.Size(); remain > 0; {
+			n := int64(len(buf))
+			if remain < n {
+				n = remain
+			}
+			if w.Random {
+				_, err = rand.Read(buf[:n])
+				if err != nil {
+					return err
+				}
+			}
+			_, err = file.Write(buf[:n])
+			if err != nil {
+				return err
+			}
+			remain -= n
+		}
+
+		// 上書きした内容をディスクに書き込む
+		err = file.Sync()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
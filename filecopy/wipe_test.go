@@ -0,0 +1,75 @@
+package filecopy
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWipeOverwrite(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	err := os.MkdirAll(testDir, 0755)
+	require.NoError(t, err, "準備：ディレクトリ作成 %s", testDir)
+	file := filepath.Join(testDir, "secret.txt")
+	data := bytes.Repeat([]byte("secret"), wipeBufferSize/3)
+
+	for _, random := range []bool{false, true} {
+		require.NoError(t, os.WriteFile(file, data, 0644))
+
+		// 内容を上書きしてサイズを0にする
+		f, err := os.Open(file)
+		require.NoError(t, err, "Open")
+		err = Wipe{Passes: 2, Random: random}.overwrite(file)
+		require.NoError(t, err, "overwrite")
+		info, err := f.Stat()
+		require.NoError(t, err, "Stat")
+		assert.Equal(t, int64(0), info.Size(), "切り詰め")
+		f.Close()
+
+		// 削除
+		require.NoError(t, os.WriteFile(file, data, 0644))
+		err = Wipe{Passes: 1, Random: random}.Remove(file)
+		require.NoError(t, err, "Remove")
+		assert.NoFileExists(t, file, "削除")
+		entries, _ := os.ReadDir(testDir)
+		assert.Len(t, entries, 0, "名前を変更したファイルも残らない")
+	}
+
+	// 存在しないファイル
+	assert.Error(t, Wipe{Passes: 1}.Remove(file), "存在しないファイル")
+}
+
+func TestWipeLinks(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	tree := filepath.Join(testDir, "tree")
+	require.NoError(t, os.MkdirAll(tree, 0755))
+	outside := filepath.Join(testDir, "outside.txt")
+	require.NoError(t, os.WriteFile(outside, []byte("outside"), 0644))
+
+	// シンボリックリンクはリンクのみ削除し、リンク先(ツリーの外)は上書きしない
+	link := filepath.Join(tree, "link.txt")
+	require.NoError(t, os.Symlink(filepath.Join("..", "outside.txt"), link))
+	require.NoError(t, Wipe{Passes: 1}.Remove(link), "Remove")
+	_, err := os.Lstat(link)
+	assert.True(t, os.IsNotExist(err), "シンボリックリンクを削除")
+	data, err := os.ReadFile(outside)
+	require.NoError(t, err)
+	assert.Equal(t, "outside", string(data), "リンク先は上書きしない")
+
+	// ハードリンクされたファイルは上書きしない
+	hard := filepath.Join(tree, "hard.txt")
+	require.NoError(t, os.Link(outside, hard))
+	assert.ErrorIs(t, Wipe{Passes: 1}.Remove(hard), ErrHardLinked, "ハードリンク")
+	assert.FileExists(t, hard, "削除しない")
+	data, err = os.ReadFile(outside)
+	require.NoError(t, err)
+	assert.Equal(t, "outside", string(data), "他の名前の内容は上書きしない")
+}
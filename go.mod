@@ -5,6 +5,7 @@ go 1.23.5
 require (
 	github.com/stretchr/testify v1.10.0
 	golang.org/x/sys v0.30.0
+	golang.org/x/text v0.22.0
 )
 
 require (
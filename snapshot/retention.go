@@ -0,0 +1,73 @@
+package snapshot
+
+import (
+	"fmt"
+	"sort"
+)
+
+// スナップショットの保持ポリシー(すべて0の場合はすべて保持する)
+type Policy struct {
+	Last    int // 最新からN個を保持する
+	Daily   int // 直近D日分(スナップショットがある日)の各日の最新を保持する
+	Weekly  int // 直近W週分の各週の最新を保持する
+	Monthly int // 直近Mか月分の各月の最新を保持する
+}
+
+// ポリシーが指定されているかチェックする
+func (p Policy) IsEmpty() bool {
+	return p.Last <= 0 && p.Daily <= 0 && p.Weekly <= 0 && p.Monthly <= 0
+}
+
+// 保持するスナップショットと削除するスナップショットに分ける(いずれも古い順)
+func (p Policy) Apply(snapshots []Snapshot) (keep, expire []Snapshot) {
+	if p.IsEmpty() {
+		return snapshots, nil
+	}
+
+	// 新しい順に並べる
+	sorted := make([]Snapshot, len(snapshots))
+	copy(sorted, snapshots)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Time.After(sorted[j].Time)
+	})
+
+	kept := map[string]bool{}
+	for i := 0; i < p.Last && i < len(sorted); i++ {
+		kept[sorted[i].Name] = true
+	}
+	keepPeriods(sorted, p.Daily, kept, func(s Snapshot) string {
+		return s.Time.Format("2006-01-02")
+	})
+	keepPeriods(sorted, p.Weekly, kept, func(s Snapshot) string {
+		year, week := s.Time.ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	})
+	keepPeriods(sorted, p.Monthly, kept, func(s Snapshot) string {
+		return s.Time.Format("2006-01")
+	})
+
+	for _, s := range snapshots {
+		if kept[s.Name] {
+			keep = append(keep, s)
+		} else {
+			expire = append(expire, s)
+		}
+	}
+	return keep, expire
+}
+
+// 期間ごとに最新のスナップショットを保持する(新しい期間からcount個)
+func keepPeriods(sorted []Snapshot, count int, kept map[string]bool, period func(Snapshot) string) {
+	seen := map[string]bool{}
+	for _, s := range sorted {
+		if len(seen) >= count {
+			return
+		}
+		key := period(s)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		kept[s.Name] = true
+	}
+}
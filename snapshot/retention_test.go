@@ -0,0 +1,47 @@
+package snapshot
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyApply(t *testing.T) {
+	// 2026/08/01 から 2026/10/17 まで毎日2回のスナップショット
+	snapshots := []Snapshot{}
+	start := time.Date(2026, 8, 1, 0, 0, 0, 0, time.Local)
+	end := time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local)
+	for t := start; t.Before(end); t = t.Add(12 * time.Hour) {
+		snapshots = append(snapshots, Snapshot{Name: Name(t), Time: t})
+	}
+
+	names := func(list []Snapshot) []string {
+		result := []string{}
+		for _, s := range list {
+			result = append(result, s.Name)
+		}
+		return result
+	}
+
+	tests := []struct {
+		name   string
+		policy Policy
+		keep   []string
+	}{
+		{"empty", Policy{}, names(snapshots)},
+		{"last", Policy{Last: 3}, []string{"20261016T120000", "20261017T000000", "20261017T120000"}},
+		{"daily", Policy{Daily: 2}, []string{"20261016T120000", "20261017T120000"}},
+		{"weekly", Policy{Weekly: 2}, []string{"20261011T120000", "20261017T120000"}},
+		{"monthly", Policy{Monthly: 3}, []string{"20260831T120000", "20260930T120000", "20261017T120000"}},
+		{"mixed", Policy{Last: 1, Daily: 2, Monthly: 2}, []string{"20260930T120000", "20261016T120000", "20261017T120000"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			keep, expire := tt.policy.Apply(snapshots)
+			assert.Equal(t, tt.keep, names(keep), "保持するスナップショット")
+			assert.Len(t, expire, len(snapshots)-len(tt.keep), "削除するスナップショット")
+		})
+	}
+}
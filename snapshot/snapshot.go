@@ -0,0 +1,61 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// スナップショットディレクトリ名の書式
+const TimeFormat = "20060102T150405"
+
+// 日時付きのバックアップディレクトリ
+type Snapshot struct {
+	Name string
+	Path string
+	Time time.Time
+}
+
+// スナップショットディレクトリ名を作成する
+func Name(t time.Time) string {
+	return t.Format(TimeFormat)
+}
+
+// 指定ディレクトリ直下のスナップショットを古い順に取得する
+func List(root string) ([]Snapshot, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []Snapshot{}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		t, err := time.ParseInLocation(TimeFormat, entry.Name(), time.Local)
+		if err != nil {
+			continue
+		}
+		snapshots = append(snapshots, Snapshot{
+			Name: entry.Name(),
+			Path: filepath.Join(root, entry.Name()),
+			Time: t,
+		})
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].Time.Before(snapshots[j].Time)
+	})
+	return snapshots, nil
+}
+
+// 最新のスナップショットを取得する(存在しない場合はfalse)
+func Latest(root string) (Snapshot, bool) {
+	snapshots, err := List(root)
+	if err != nil || len(snapshots) == 0 {
+		return Snapshot{}, false
+	}
+	return snapshots[len(snapshots)-1], true
+}
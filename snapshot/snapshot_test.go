@@ -0,0 +1,43 @@
+package snapshot
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coco-papiyon/mechacopy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDir = "testdata"
+
+func TestList(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// スナップショットがない場合
+	_, ok := Latest(testDir)
+	assert.False(t, ok, "Latest")
+
+	tt := testutil.TestCase{
+		TestDirs:  []string{"20261017T120000", "20261001T000000", "20261018T090000", "other"},
+		TestFiles: []string{"20261019T000000"},
+	}
+	testutil.PrepareDirs(t, tt, testDir)
+
+	snapshots, err := List(testDir)
+	require.NoError(t, err, "List")
+	names := []string{}
+	for _, s := range snapshots {
+		names = append(names, s.Name)
+	}
+	assert.Equal(t, []string{"20261001T000000", "20261017T120000", "20261018T090000"}, names, "古い順")
+
+	latest, ok := Latest(testDir)
+	require.True(t, ok, "Latest")
+	assert.Equal(t, filepath.Join(testDir, "20261018T090000"), latest.Path, "最新のスナップショット")
+	assert.Equal(t, time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local), latest.Time, "日時")
+	assert.Equal(t, "20261018T090000", Name(latest.Time), "Name")
+}
@@ -0,0 +1,232 @@
+package trash
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coco-papiyon/mechacopy/filecopy"
+)
+
+// .trashinfo の削除日時の書式
+const dateFormat = "2006-01-02T15:04:05"
+
+const infoExt = ".trashinfo"
+
+// ゴミ箱(XDG Trash 仕様のディレクトリ構成: files/ と info/)
+type Trash struct {
+	Dir  string // ゴミ箱のディレクトリ
+	Flat bool   // files/ 直下にファイル名のみで格納する(XDG Trash)。falseの場合は相対パスを維持する
+}
+
+// ゴミ箱に入っているファイル
+type Item struct {
+	Name         string    // files/ からの相対パス
+	Path         string    // 削除前のパス
+	DeletionDate time.Time // 削除日時
+}
+
+// ホームディレクトリのゴミ箱($XDG_DATA_HOME/Trash)を取得する
+func XDG() (*Trash, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return &Trash{Dir: filepath.Join(dataHome, "Trash"), Flat: true}, nil
+}
+
+// 指定ディレクトリを隔離用のゴミ箱にする(削除対象からの相対パスを維持する)
+func New(dir string) *Trash {
+	return &Trash{Dir: dir}
+}
+
+func (t *Trash) filesDir() string {
+	return filepath.Join(t.Dir, "files")
+}
+
+func (t *Trash) infoDir() string {
+	return filepath.Join(t.Dir, "info")
+}
+
+// ファイルをゴミ箱に移動する
+func (t *Trash) Put(path, relPath string) error {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	name := relPath
+	if t.Flat {
+		name = filepath.Base(path)
+	}
+
+	// 情報ファイルを先に作成して格納先の名前を確保する
+	name, err = t.writeInfo(name, abs, time.Now())
+	if err != nil {
+		return err
+	}
+
+	// ファイルを移動する(別のファイルシステムの場合はコピーしてから削除する)
+	dst := filepath.Join(t.filesDir(), name)
+	err = move(abs, dst)
+	if err != nil {
+		os.Remove(filepath.Join(t.infoDir(), name+infoExt))
+		return err
+	}
+	return nil
+}
+
+// 情報ファイルを作成する(同名のファイルがある場合は「名前.2」のように番号を付ける)
+func (t *Trash) writeInfo(name, path string, date time.Time) (string, error) {
+	content := fmt.Sprintf("[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		(&url.URL{Path: filepath.ToSlash(path)}).EscapedPath(), date.Format(dateFormat))
+
+	base := name
+	for i := 2; ; i++ {
+		info := filepath.Join(t.infoDir(), name+infoExt)
+		err := os.MkdirAll(filepath.Dir(info), os.ModePerm)
+		if err != nil {
+			return "", err
+		}
+		f, err := os.OpenFile(info, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if errors.Is(err, fs.ErrExist) {
+			name = base + "." + strconv.Itoa(i)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		if _, err := os.Lstat(filepath.Join(t.filesDir(), name)); err == nil {
+			// 情報ファイルのないファイルが残っている場合は別の名前にする
+			f.Close()
+			os.Remove(info)
+			name = base + "." + strconv.Itoa(i)
+			continue
+		}
+		_, err = f.WriteString(content)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return name, err
+	}
+}
+
+// ゴミ箱のファイル一覧を取得する
+func (t *Trash) List() ([]Item, error) {
+	items := []Item{}
+	err := filepath.WalkDir(t.infoDir(), func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(path, infoExt) {
+			return nil
+		}
+		item, err := readInfo(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(t.infoDir(), path)
+		item.Name = strings.TrimSuffix(rel, infoExt)
+		items = append(items, item)
+		return nil
+	})
+	return items, err
+}
+
+// 情報ファイルを読み込む
+func readInfo(path string) (Item, error) {
+	item := Item{}
+	f, err := os.Open(path)
+	if err != nil {
+		return item, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Path":
+			p, err := url.PathUnescape(value)
+			if err != nil {
+				return item, fmt.Errorf("%s: %w", path, err)
+			}
+			item.Path = filepath.FromSlash(p)
+		case "DeletionDate":
+			item.DeletionDate, _ = time.ParseInLocation(dateFormat, value, time.Local)
+		}
+	}
+	if item.Path == "" {
+		return item, fmt.Errorf("%s: Path がありません", path)
+	}
+	return item, scanner.Err()
+}
+
+// ファイルを元の場所に戻す(元の場所にファイルがある場合はエラー)
+func (t *Trash) Restore(item Item) error {
+	if _, err := os.Lstat(item.Path); err == nil {
+		return fmt.Errorf("復元先にファイルが存在します: %s", item.Path)
+	}
+	err := os.MkdirAll(filepath.Dir(item.Path), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = move(filepath.Join(t.filesDir(), item.Name), item.Path)
+	if err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(t.infoDir(), item.Name+infoExt))
+}
+
+// ゴミ箱のファイルを完全に削除する
+func (t *Trash) Remove(item Item) error {
+	err := os.RemoveAll(filepath.Join(t.filesDir(), item.Name))
+	if err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(t.infoDir(), item.Name+infoExt))
+}
+
+// ゴミ箱を空にする
+func (t *Trash) Empty() error {
+	for _, dir := range []string{t.filesDir(), t.infoDir()} {
+		err := os.RemoveAll(dir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ファイルを移動する(別のファイルシステムの場合はコピーしてから削除する)
+func move(src, dst string) error {
+	err := os.MkdirAll(filepath.Dir(dst), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	err = filecopy.CopyFile(src, dst)
+	if err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,90 @@
+package trash
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coco-papiyon/mechacopy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDir = "testdata"
+
+func TestTrash(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	tests := []struct {
+		name  string
+		trash *Trash
+		names []string
+	}{
+		{"xdg", nil, []string{"file1.txt", "file1.txt.2", "file 2.txt"}},
+		{"quarantine", New(filepath.Join(testDir, "quarantine")), []string{"a/file1.txt", "b/file1.txt", "file 2.txt"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 準備
+			srcDir := filepath.Join(testDir, tt.name)
+			files := []string{"a/file1.txt", "b/file1.txt", "file 2.txt"}
+			testutil.PrepareDirs(t, testutil.TestCase{TestFiles: files}, srcDir)
+			trash := tt.trash
+			if trash == nil {
+				t.Setenv("XDG_DATA_HOME", filepath.Join(testDir, "share"))
+				var err error
+				trash, err = XDG()
+				require.NoError(t, err, "XDG")
+				assert.Equal(t, filepath.Join(testDir, "share", "Trash"), trash.Dir, "XDG Trash")
+			}
+
+			// ゴミ箱へ移動
+			for i, file := range files {
+				err := trash.Put(filepath.Join(srcDir, file), file)
+				require.NoError(t, err, "Put %s", file)
+				assert.NoFileExists(t, filepath.Join(srcDir, file), "移動元")
+				assert.FileExists(t, filepath.Join(trash.Dir, "files", tt.names[i]), "移動先")
+				assert.FileExists(t, filepath.Join(trash.Dir, "info", tt.names[i]+".trashinfo"), "情報ファイル")
+			}
+			data, err := os.ReadFile(filepath.Join(trash.Dir, "info", tt.names[2]+".trashinfo"))
+			require.NoError(t, err, "ReadFile")
+			assert.Contains(t, string(data), "[Trash Info]\nPath=/", "情報ファイル")
+			assert.Contains(t, string(data), "file%202.txt\nDeletionDate=", "情報ファイル")
+
+			// 一覧
+			items, err := trash.List()
+			require.NoError(t, err, "List")
+			require.Len(t, items, len(files), "List")
+			for _, item := range items {
+				assert.Contains(t, tt.names, item.Name, "Name")
+				assert.False(t, item.DeletionDate.IsZero(), "DeletionDate")
+			}
+
+			// 元の場所に戻す
+			for _, item := range items {
+				require.NoError(t, trash.Restore(item), "Restore %s", item.Path)
+			}
+			for _, file := range files {
+				assert.FileExists(t, filepath.Join(srcDir, file), "復元")
+			}
+			items, err = trash.List()
+			require.NoError(t, err, "List")
+			assert.Len(t, items, 0, "List")
+
+			// 空にする
+			require.NoError(t, trash.Put(filepath.Join(srcDir, files[0]), files[0]), "Put")
+			items, _ = trash.List()
+			require.NoError(t, trash.Restore(items[0]), "Restore")
+			require.NoError(t, trash.Put(filepath.Join(srcDir, files[0]), files[0]), "Put")
+			testutil.CreateTestFile(filepath.Join(srcDir, files[0]))
+			items, _ = trash.List()
+			assert.Error(t, trash.Restore(items[0]), "復元先にファイルが存在する")
+			require.NoError(t, trash.Empty(), "Empty")
+			items, err = trash.List()
+			require.NoError(t, err, "List")
+			assert.Len(t, items, 0, "List")
+		})
+	}
+}
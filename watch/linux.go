@@ -0,0 +1,152 @@
+//go:build linux
+
+package watch
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// 監視する変更
+const watchMask = unix.IN_CREATE | unix.IN_DELETE | unix.IN_MODIFY | unix.IN_CLOSE_WRITE |
+	unix.IN_ATTRIB | unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_ONLYDIR
+
+// inotify で監視を開始する
+func (w *Watcher) start() error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return err
+	}
+	// ノンブロッキングのファイルとして扱い、Close で読み込みを中断できるようにする
+	// (Fd() はブロッキングモードに戻すため、監視の追加には fd を使用する)
+	w.fd = fd
+	w.file = os.NewFile(uintptr(fd), "inotify")
+
+	_, err = w.addTree(".")
+	if err != nil {
+		w.file.Close()
+		return err
+	}
+	go w.read()
+	return nil
+}
+
+func (w *Watcher) close() error {
+	return w.file.Close()
+}
+
+// ディレクトリ以下をすべて監視対象にする(追加したディレクトリを返す)
+func (w *Watcher) addTree(relDir string) ([]string, error) {
+	added := []string{}
+	root := filepath.Join(w.Root, relDir)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// 走査中に削除された、読み込めないディレクトリは監視しない
+			if path != root || relDir != "." {
+				return nil
+			}
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		rel, _ := filepath.Rel(w.Root, path)
+		if rel != "." && w.SkipDir != nil && w.SkipDir(rel) {
+			return filepath.SkipDir
+		}
+		err = w.add(rel)
+		if err != nil && path == root && relDir == "." {
+			return err
+		}
+		added = append(added, rel)
+		return nil
+	})
+	return added, err
+}
+
+// ディレクトリを監視対象にする
+func (w *Watcher) add(relDir string) error {
+	wd, err := unix.InotifyAddWatch(w.fd, filepath.Join(w.Root, relDir), watchMask)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dirs[wd] = relDir
+	return nil
+}
+
+func (w *Watcher) dir(wd int) (string, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	dir, ok := w.dirs[wd]
+	return dir, ok
+}
+
+func (w *Watcher) remove(wd int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.dirs, wd)
+}
+
+// 変更を読み込んで通知する
+func (w *Watcher) read() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.error(err)
+			}
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			name := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+			if !w.handle(event, string(bytes.TrimRight(name, "\x00"))) {
+				return
+			}
+		}
+	}
+}
+
+// 変更を通知する(終了した場合はfalse)
+func (w *Watcher) handle(event *unix.InotifyEvent, name string) bool {
+	if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+		return w.error(ErrOverflow)
+	}
+	dir, ok := w.dir(int(event.Wd))
+	if !ok {
+		return true
+	}
+	if event.Mask&unix.IN_IGNORED != 0 {
+		// 削除されたディレクトリ
+		w.remove(int(event.Wd))
+		return true
+	}
+	if !w.notify(dir) {
+		return false
+	}
+
+	// 作成、移動されたディレクトリを監視対象にし、中のファイルも変更として通知する
+	if event.Mask&unix.IN_ISDIR != 0 && event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+		added, err := w.addTree(filepath.Join(dir, name))
+		if err != nil {
+			return w.error(err)
+		}
+		for _, sub := range added {
+			if !w.notify(sub) {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package watch
+
+func (w *Watcher) start() error {
+	return ErrNotSupported
+}
+
+func (w *Watcher) close() error {
+	return nil
+}
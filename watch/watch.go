@@ -0,0 +1,75 @@
+package watch
+
+import (
+	"errors"
+	"os"
+	"sync"
+)
+
+// 変更の監視に対応していない環境
+var ErrNotSupported = errors.New("ディレクトリの監視に対応していません")
+
+// 通知が多すぎて取りこぼした(全体の再走査が必要)
+var ErrOverflow = errors.New("変更の通知が溢れました")
+
+// ディレクトリ以下の変更を監視する(サブディレクトリも監視する)
+type Watcher struct {
+	Root    string
+	SkipDir func(string) bool // 監視しないディレクトリ(ルートからの相対パス)
+	Events  chan string       // 変更があったディレクトリ(ルートからの相対パス)
+	Errors  chan error
+
+	mu   sync.Mutex
+	fd   int
+	file *os.File
+	dirs map[int]string // 監視ID -> ディレクトリ
+	done chan struct{}
+}
+
+// ディレクトリの監視を開始する
+func New(root string, skipDir func(string) bool) (*Watcher, error) {
+	w := &Watcher{
+		Root:    root,
+		SkipDir: skipDir,
+		Events:  make(chan string, 256),
+		Errors:  make(chan error, 16),
+		dirs:    map[int]string{},
+		done:    make(chan struct{}),
+	}
+	err := w.start()
+	if err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// 監視を終了する
+func (w *Watcher) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+	}
+	close(w.done)
+	return w.close()
+}
+
+// 変更があったディレクトリを通知する(終了後は通知しない)
+func (w *Watcher) notify(dir string) bool {
+	select {
+	case w.Events <- dir:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+// エラーを通知する
+func (w *Watcher) error(err error) bool {
+	select {
+	case w.Errors <- err:
+		return true
+	case <-w.done:
+		return false
+	}
+}
@@ -0,0 +1,77 @@
+package watch
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coco-papiyon/mechacopy/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testDir = "testdata"
+
+// 指定したディレクトリの変更が通知されるまで待つ
+func waitEvents(t *testing.T, w *Watcher, dirs ...string) {
+	want := map[string]bool{}
+	for _, dir := range dirs {
+		want[dir] = true
+	}
+	timeout := time.After(5 * time.Second)
+	for len(want) > 0 {
+		select {
+		case dir := <-w.Events:
+			delete(want, dir)
+		case err := <-w.Errors:
+			require.NoError(t, err, "Errors")
+		case <-timeout:
+			t.Fatalf("変更が通知されません: %v", want)
+		}
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	tt := testutil.TestCase{
+		TestDirs: []string{"a", "a/b", "skip"},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+	testutil.PrepareDirs(t, tt, testDir)
+
+	w, err := New(testDir, func(dir string) bool { return dir == "skip" })
+	if errors.Is(err, ErrNotSupported) {
+		t.Skip(err)
+	}
+	require.NoError(t, err, "New")
+	defer w.Close()
+
+	// ファイルの作成
+	assert.NoError(t, testutil.CreateTestFile(filepath.Join(testDir, "a", "b", "file.txt")))
+	waitEvents(t, w, filepath.Join("a", "b"))
+
+	// 作成したディレクトリは中のファイルも含めて通知され、以降も監視される
+	assert.NoError(t, testutil.CreateTestFile(filepath.Join(testDir, "new", "sub", "file.txt")))
+	waitEvents(t, w, ".", "new", filepath.Join("new", "sub"))
+	assert.NoError(t, testutil.CreateTestFile(filepath.Join(testDir, "new", "sub", "file2.txt")))
+	waitEvents(t, w, filepath.Join("new", "sub"))
+
+	// 監視しないディレクトリは通知されない
+	assert.NoError(t, testutil.CreateTestFile(filepath.Join(testDir, "skip", "file.txt")))
+	timeout := time.After(200 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case dir := <-w.Events:
+			assert.NotEqual(t, "skip", dir, "監視しないディレクトリ")
+		case <-timeout:
+			done = true
+		}
+	}
+
+	// 終了後に Close しても問題ないこと
+	assert.NoError(t, w.Close(), "Close")
+	assert.NoError(t, w.Close(), "Close")
+}
@@ -0,0 +1,265 @@
+package worker
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coco-papiyon/mechacopy/archive"
+	"github.com/coco-papiyon/mechacopy/filecopy"
+)
+
+// tar の場合にメモリに読み込んで並行して書き込むファイルサイズの上限
+// (大きいファイルはアーカイブを読み込みながら書き込む)
+const archiveBufferSize = 4 << 20
+
+// アーカイブをコピー元としてコピーする(対象ファイル、差分の判定はディレクトリのコピーと同じ)
+func RunArchive(srcFile, dstDir string, config *Config) error {
+	start := time.Now()
+
+	a, err := archive.Open(srcFile)
+	if err != nil {
+		slog.Error("アーカイブ", "ERROR", err, "file", srcFile)
+		return err
+	}
+	defer a.Close()
+
+	// コピー対象ファイルの判定(ディレクトリごとのルールファイルはアーカイブでは使用しない)
+	filterConfig := *config
+	filterConfig.IgnoreFile = ""
+	filter, err := newFilter(srcFile, &filterConfig)
+	if err != nil {
+		slog.Error("ファイルパターン", "ERROR", err)
+		return err
+	}
+
+	// 同時実行用の制御
+	job := newJob(config, filter)
+	r := &archiveRunner{archive: a, dst: dstDir, job: job}
+	err = r.run(nil)
+	if err != nil {
+		slog.Error("アーカイブの読み込み", "ERROR", err, "file", srcFile)
+		return err
+	}
+	for _, name := range a.Invalid() {
+		slog.Error("Archive Entry", "file", name, "ERROR", "アーカイブの外を指すパスです")
+		job.AddListErrorFile(name)
+	}
+	for _, name := range a.Duplicated() {
+		slog.Warn("Archive Entry", "file", name, "reason", "同じパスの後のエントリをコピーします")
+		job.AddSkipFile(SkipDuplicate)
+	}
+
+	// エラーリトライ(アーカイブを先頭から読み直す)
+	for i := 0; config.Retry && i < config.RetryCount; i++ {
+		errCount := len(job.errorFiles)
+		if errCount == 0 {
+			break
+		}
+
+		slog.Info("Retry Error Files", "Count", i, "Files", errCount)
+		time.Sleep(time.Duration(config.SleepTime) * time.Second)
+
+		targets := map[string]bool{}
+		for _, file := range job.errorFiles {
+			targets[file] = true
+		}
+		job.errorFiles = []string{}
+		err = r.run(targets)
+		if err != nil {
+			slog.Error("アーカイブの読み込み", "ERROR", err, "file", srcFile)
+			return err
+		}
+	}
+
+	// 不正なパスのエントリはリトライせずにエラーとして記録する
+	job.errorFiles = append(job.errorFiles, job.listErrorFiles...)
+
+	if !config.Quiet {
+		printSummary(start, job)
+	}
+	return nil
+}
+
+// アーカイブのエントリをコピーする
+type archiveRunner struct {
+	archive *archive.Archive
+	dst     string
+	job     *JobStatus
+}
+
+// 書き込むエントリ(tar の場合は読み込んだ内容)
+type archiveItem struct {
+	entry    *archive.Entry
+	relFile  string
+	diff     filecopy.FileDiff
+	data     []byte
+	buffered bool
+}
+
+// アーカイブを先頭から読み込み、対象ファイルを並行して書き込む
+// (targets を指定した場合はリトライとして指定したファイルのみ書き込む)
+func (r *archiveRunner) run(targets map[string]bool) error {
+	job := r.job
+	ch := make(chan archiveItem)
+	for i := 0; i < job.config.CopyThread; i++ {
+		go r.worker(ch, targets != nil)
+	}
+
+	err := r.archive.Walk(func(entry *archive.Entry) error {
+		relFile := filepath.FromSlash(entry.Name)
+		item, ok := r.selectEntry(entry, relFile, targets)
+		if !ok {
+			return nil
+		}
+
+		// tar は順に読み込むため、小さいファイルは読み込んでから並行して書き込む
+		if !r.archive.RandomAccess() {
+			if entry.Info.Size() > archiveBufferSize {
+				job.AddTotal()
+				job.wg.Add(1)
+				r.write(item, targets != nil)
+				return nil
+			}
+			data, err := readEntry(entry)
+			if err != nil {
+				return err
+			}
+			item.data = data
+			item.buffered = true
+		}
+		job.AddTotal()
+		job.wg.Add(1)
+		ch <- item
+		return nil
+	})
+	close(ch)
+
+	// 処理待ち
+	job.wg.Wait()
+	return err
+}
+
+// コピー対象のエントリかチェックする(対象外、差分がない場合はスキップとして記録する)
+func (r *archiveRunner) selectEntry(entry *archive.Entry, relFile string, targets map[string]bool) (archiveItem, bool) {
+	job := r.job
+	item := archiveItem{entry: entry, relFile: relFile}
+
+	// リトライの場合は指定したファイルのみ
+	if targets != nil {
+		return item, targets[relFile]
+	}
+
+	// ディレクトリ、シンボリックリンク等は対象外
+	if !entry.Info.Mode().IsRegular() {
+		if !entry.Info.IsDir() {
+			slog.Warn("Archive Entry", "file", entry.Name, "type", entry.Info.Mode().Type().String())
+		}
+		return item, false
+	}
+
+	// 除外ディレクトリ、階層数
+	dir := filepath.Dir(relFile)
+	if r.isExcludedDir(dir) {
+		job.AddSkipFile(SkipPattern)
+		return item, false
+	}
+
+	// 対象ファイル(パターン、サイズ、更新日時)
+	if _, ok := selectFile(entry.Name, relFile, fs.FileInfoToDirEntry(entry.Info), job); !ok {
+		return item, false
+	}
+
+	// 差分がない場合、上書きしない場合はコピーしない
+	item.diff = filecopy.CompareInfo(entry.Info.Size(), entry.Info.ModTime(), filepath.Join(r.dst, relFile))
+	if reason, skip := skipOverwrite(item.diff, job.config); skip {
+		slog.Debug("Skip File", "file", relFile, "reason", reason)
+		job.AddSkipFile(reason)
+		return item, false
+	}
+	return item, true
+}
+
+// 除外ディレクトリ、または指定階層より下のディレクトリかチェックする(起点が1階層目)
+func (r *archiveRunner) isExcludedDir(dir string) bool {
+	if dir == "." {
+		return false
+	}
+	parts := strings.Split(dir, string(filepath.Separator))
+	if r.job.config.MaxDepth > 0 && len(parts)+1 > r.job.config.MaxDepth {
+		return true
+	}
+	for i := range parts {
+		if r.job.filter.IsExcludeDir(filepath.Join(parts[:i+1]...)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *archiveRunner) worker(ch <-chan archiveItem, retry bool) {
+	for item := range ch {
+		r.write(item, retry)
+	}
+}
+
+// エントリをコピー先に書き込む
+func (r *archiveRunner) write(item archiveItem, retry bool) {
+	job := r.job
+	defer job.wg.Done()
+	dstFile := filepath.Join(r.dst, item.relFile)
+
+	// 上書きする場合はコピー先ファイルを退避する(退避できない場合はリトライしない)
+	if !retry && r.job.backup != nil && item.diff != filecopy.DiffMissing {
+		err := r.job.backup.Save(dstFile, item.relFile)
+		if err != nil {
+			slog.Error("Backup File", "file", dstFile, "ERROR", err)
+			job.AddBackupErrorFile(item.relFile)
+			job.AddError()
+			return
+		}
+	}
+
+	err := r.writeFile(item, dstFile)
+	if err != nil {
+		slog.Error("File Copy", "file", item.entry.Name, "ERROR", err)
+		job.AddErrorFile(item.relFile)
+		job.AddError()
+		return
+	}
+	if !retry {
+		job.AddSuccessFile()
+	}
+	job.AddSuccess()
+	slog.Info(fmt.Sprintf("%s %s", job.GetStatus(), item.relFile))
+}
+
+func (r *archiveRunner) writeFile(item archiveItem, dstFile string) error {
+	var src io.Reader
+	if item.buffered {
+		src = bytes.NewReader(item.data)
+	} else {
+		rc, err := item.entry.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		src = rc
+	}
+	return filecopy.CopyReader(src, dstFile, item.entry.Info.ModTime(), item.entry.Info.Mode().Perm())
+}
+
+// エントリの内容を読み込む
+func readEntry(entry *archive.Entry) ([]byte, error) {
+	rc, err := entry.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
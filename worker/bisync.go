@@ -0,0 +1,523 @@
+package worker
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coco-papiyon/mechacopy/filecopy"
+)
+
+// 競合(両方で変更された)の解決方法
+type ConflictMode string
+
+const (
+	ConflictNewer    ConflictMode = "newer"  // 更新日時が新しい方を残す
+	ConflictKeepBoth ConflictMode = "keep"   // 新しい方を残し、古い方は名前を変えて両方に残す
+	ConflictReport   ConflictMode = "report" // 報告のみ(どちらも変更しない)
+)
+
+// 競合したファイルの名前に付ける文字列
+const conflictSuffix = ".conflict-"
+
+// 同期したファイルの状態
+type SyncFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+func (f SyncFile) equal(other SyncFile) bool {
+	return f.Size == other.Size && f.ModTime.Equal(other.ModTime)
+}
+
+// 前回の同期の状態
+type SyncState struct {
+	A     string              `json:"a"`
+	B     string              `json:"b"`
+	Time  time.Time           `json:"time"`
+	Files map[string]SyncFile `json:"files"`
+}
+
+// 同期の操作
+type syncAction struct {
+	path     string
+	from, to string // コピー元、コピー先(削除の場合は to のみ)
+	delete   bool
+	conflict string // 競合した場合、古い方を退避する名前
+	loser    string // 古い方のディレクトリ
+}
+
+// 競合したファイル
+type SyncConflict struct {
+	Path       string       `json:"path"`
+	Resolution ConflictMode `json:"resolution"`
+	Kept       string       `json:"kept,omitempty"` // 残した方(a/b)
+	Renamed    string       `json:"renamed,omitempty"`
+}
+
+// 同期結果
+type SyncReport struct {
+	CopyAToB  []string       `json:"copy_a_to_b"`
+	CopyBToA  []string       `json:"copy_b_to_a"`
+	DeleteA   []string       `json:"delete_a"`
+	DeleteB   []string       `json:"delete_b"`
+	Conflicts []SyncConflict `json:"conflicts"`
+	Errors    []string       `json:"errors"`
+}
+
+// 2つのディレクトリを双方向に同期する
+type BiSync struct {
+	A, B     string
+	State    string       // 状態ファイル
+	Conflict ConflictMode // 競合の解決方法
+	DryRun   bool         // 操作を決めるのみで実行しない(削除の安全確認は行う)
+	Now      time.Time    // 競合したファイルの名前に付ける日時
+
+	// 削除の安全確認(未マウントの共有フォルダー等で片方が空の場合に削除を反映しない)
+	MaxDelete int  // 削除するファイル数の上限(0は無制限)
+	Force     bool // 片方が空の場合、上限を超える場合も実行する
+}
+
+// 片方が空になった(前回の状態はある)ため中止した
+var ErrSyncSideEmpty = errors.New("前回の同期後に片方のディレクトリが空になっています")
+
+// 状態ファイルの既定の場所($XDG_STATE_HOME/mechacopy 以下、ディレクトリの組ごとに作成)
+func DefaultStatePath(a, b string) (string, error) {
+	absA, err := filepath.Abs(a)
+	if err != nil {
+		return "", err
+	}
+	absB, err := filepath.Abs(b)
+	if err != nil {
+		return "", err
+	}
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		stateHome = filepath.Join(home, ".local", "state")
+	}
+	hash := sha256.Sum256([]byte(absA + "\x00" + absB))
+	return filepath.Join(stateHome, "mechacopy", "bisync-"+hex.EncodeToString(hash[:8])+".json"), nil
+}
+
+// 状態ファイルを読み込む(存在しない場合は空の状態)
+func LoadSyncState(file string) (*SyncState, error) {
+	state := &SyncState{Files: map[string]SyncFile{}}
+	data, err := os.ReadFile(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, state)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	if state.Files == nil {
+		state.Files = map[string]SyncFile{}
+	}
+	return state, nil
+}
+
+// 状態ファイルを保存する(書き込み途中で壊れないよう一時ファイルから置き換える)
+func (state *SyncState) Save(file string) error {
+	err := os.MkdirAll(filepath.Dir(file), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := file + ".tmp"
+	err = os.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+// 同期を実行する
+func (s *BiSync) Run(config *Config) (*SyncReport, error) {
+	if s.Now.IsZero() {
+		s.Now = time.Now()
+	}
+	prev, err := LoadSyncState(s.State)
+	if err != nil {
+		slog.Error("状態ファイル", "ERROR", err, "file", s.State)
+		return nil, err
+	}
+
+	// 両方のファイル一覧を取得(読み込めないディレクトリがある場合は削除と区別できないため中止)
+	var filesA, filesB map[string]SyncFile
+	var errA, errB error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		filesA, errA = scanSyncFiles(s.A, config)
+	}()
+	go func() {
+		defer wg.Done()
+		filesB, errB = scanSyncFiles(s.B, config)
+	}()
+	wg.Wait()
+	if err := errors.Join(errA, errB); err != nil {
+		slog.Error("ファイル一覧", "ERROR", err)
+		return nil, err
+	}
+
+	// 前回からの変更を比較して操作を決める
+	// 削除の安全確認(試行の場合も実行時に中止するか分かるよう確認する)
+	actions, report := s.plan(prev.Files, filesA, filesB)
+	err = s.checkDelete(prev.Files, filesA, filesB, report)
+	if err != nil {
+		slog.Error("同期を中止", "ERROR", err)
+		return report, err
+	}
+	if s.DryRun {
+		return report, nil
+	}
+
+	// 操作を実行
+	failed := s.apply(actions, config, report)
+
+	// 状態を保存(両方が一致したファイルのみ、失敗、報告のみの競合は前回の状態のまま)
+	next := &SyncState{A: s.A, B: s.B, Time: s.Now, Files: map[string]SyncFile{}}
+	conflicts := map[string]bool{}
+	for _, c := range report.Conflicts {
+		if c.Resolution == ConflictReport {
+			conflicts[c.Path] = true
+		}
+	}
+	for path := range union(prev.Files, filesA, filesB) {
+		if conflicts[path] || failed[path] {
+			if file, ok := prev.Files[path]; ok {
+				next.Files[path] = file
+			}
+			continue
+		}
+		a, okA := statSyncFile(filepath.Join(s.A, path))
+		b, okB := statSyncFile(filepath.Join(s.B, path))
+		if okA && okB && a.equal(b) {
+			next.Files[path] = a
+		}
+	}
+	for _, action := range actions {
+		if action.conflict != "" && !failed[action.path] {
+			if file, ok := statSyncFile(filepath.Join(s.A, action.conflict)); ok {
+				next.Files[action.conflict] = file
+			}
+		}
+	}
+	err = next.Save(s.State)
+	if err != nil {
+		slog.Error("状態ファイル", "ERROR", err, "file", s.State)
+		return report, err
+	}
+	return report, nil
+}
+
+// 前回の状態と比較して操作を決める
+func (s *BiSync) plan(prev, filesA, filesB map[string]SyncFile) ([]syncAction, *SyncReport) {
+	report := &SyncReport{}
+	actions := []syncAction{}
+	paths := []string{}
+	for path := range union(prev, filesA, filesB) {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		old, okPrev := prev[path]
+		a, okA := filesA[path]
+		b, okB := filesB[path]
+		changedA := okA != okPrev || (okA && !a.equal(old))
+		changedB := okB != okPrev || (okB && !b.equal(old))
+
+		switch {
+		case !changedA && !changedB:
+			continue
+		case okA && okB && a.equal(b):
+			// 両方で同じ変更
+			continue
+		case !okA && !okB:
+			// 両方で削除
+			continue
+		case changedA && !changedB:
+			actions = append(actions, s.propagate(path, okA, s.A, s.B, report))
+		case changedB && !changedA:
+			actions = append(actions, s.propagate(path, okB, s.B, s.A, report))
+		default:
+			if action, ok := s.resolve(path, a, b, okA, okB, report); ok {
+				actions = append(actions, action)
+			}
+		}
+	}
+	return actions, report
+}
+
+// 削除を反映してよいかチェックする
+func (s *BiSync) checkDelete(prev, filesA, filesB map[string]SyncFile, report *SyncReport) error {
+	if s.Force {
+		return nil
+	}
+	if len(prev) > 0 {
+		switch {
+		case len(filesA) == 0:
+			return fmt.Errorf("%w: %s", ErrSyncSideEmpty, s.A)
+		case len(filesB) == 0:
+			return fmt.Errorf("%w: %s", ErrSyncSideEmpty, s.B)
+		}
+	}
+	deletes := len(report.DeleteA) + len(report.DeleteB)
+	if s.MaxDelete > 0 && deletes > s.MaxDelete {
+		return fmt.Errorf("削除するファイル数 (%d) が上限 (%d) を超えています", deletes, s.MaxDelete)
+	}
+	return nil
+}
+
+// 片方の変更をもう片方に反映する
+func (s *BiSync) propagate(path string, exist bool, from, to string, report *SyncReport) syncAction {
+	if !exist {
+		s.record(report, path, to, true)
+		return syncAction{path: path, to: to, delete: true}
+	}
+	s.record(report, path, to, false)
+	return syncAction{path: path, from: from, to: to}
+}
+
+func (s *BiSync) record(report *SyncReport, path, to string, delete bool) {
+	switch {
+	case to == s.B && delete:
+		report.DeleteB = append(report.DeleteB, path)
+	case to == s.A && delete:
+		report.DeleteA = append(report.DeleteA, path)
+	case to == s.B:
+		report.CopyAToB = append(report.CopyAToB, path)
+	default:
+		report.CopyBToA = append(report.CopyBToA, path)
+	}
+}
+
+// 両方で変更されたファイルの競合を解決する
+func (s *BiSync) resolve(path string, a, b SyncFile, okA, okB bool, report *SyncReport) (syncAction, bool) {
+	conflict := SyncConflict{Path: path, Resolution: s.Conflict}
+	if s.Conflict == ConflictReport {
+		report.Conflicts = append(report.Conflicts, conflict)
+		return syncAction{}, false
+	}
+
+	// 片方で削除、もう片方で変更された場合は変更を残す
+	// それ以外は更新日時が新しい方を残す(同じ場合はAを残す)
+	keepA := okA && (!okB || !b.ModTime.After(a.ModTime))
+	action := syncAction{path: path, from: s.A, to: s.B}
+	conflict.Kept = "a"
+	if !keepA {
+		action = syncAction{path: path, from: s.B, to: s.A}
+		conflict.Kept = "b"
+	}
+	if s.Conflict == ConflictKeepBoth && okA && okB {
+		action.conflict = conflictName(path, s.Now)
+		action.loser = action.to
+		conflict.Renamed = action.conflict
+	}
+	report.Conflicts = append(report.Conflicts, conflict)
+	s.record(report, path, action.to, false)
+	return action, true
+}
+
+// 競合したファイルの名前(拡張子の前に日時を付ける)
+func conflictName(path string, now time.Time) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + conflictSuffix + now.Format(filecopy.BackupTimeFormat) + ext
+}
+
+// 操作を実行する(失敗したファイルを返す)
+func (s *BiSync) apply(actions []syncAction, config *Config, report *SyncReport) map[string]bool {
+	var mu sync.Mutex
+	failed := map[string]bool{}
+	ch := make(chan syncAction)
+	var wg sync.WaitGroup
+	for i := 0; i < config.CopyThread; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for action := range ch {
+				err := s.applyAction(action)
+				if err != nil {
+					slog.Error("Sync File", "file", action.path, "ERROR", err)
+					mu.Lock()
+					failed[action.path] = true
+					report.Errors = append(report.Errors, action.path)
+					mu.Unlock()
+					continue
+				}
+				slog.Info("Sync File", "file", action.path, "to", action.to, "delete", action.delete)
+			}
+		}()
+	}
+	for _, action := range actions {
+		ch <- action
+	}
+	close(ch)
+	wg.Wait()
+	sort.Strings(report.Errors)
+	return failed
+}
+
+// ファイルをコピー、削除する
+func (s *BiSync) applyAction(action syncAction) error {
+	dst := filepath.Join(action.to, action.path)
+	if action.delete {
+		err := os.Remove(dst)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+
+	// 競合した場合は古い方を名前を変えて両方に残す
+	if action.conflict != "" {
+		renamed := filepath.Join(action.loser, action.conflict)
+		err := os.Rename(dst, renamed)
+		if err != nil {
+			return err
+		}
+		err = filecopy.CopyFile(renamed, filepath.Join(action.from, action.conflict))
+		if err != nil {
+			return err
+		}
+	}
+	return filecopy.CopyFile(filepath.Join(action.from, action.path), dst)
+}
+
+// ファイル一覧を取得する(ワーカーで並列に走査する)
+func scanSyncFiles(dir string, config *Config) (map[string]SyncFile, error) {
+	scanConfig := *config
+	scanConfig.Quiet = true
+	scanConfig.Retry = false
+	runner := &syncScanner{files: map[string]SyncFile{}}
+	err := RunMecha(dir, runner, &scanConfig)
+	if err != nil {
+		return nil, err
+	}
+	if len(runner.errs) > 0 {
+		return nil, fmt.Errorf("%s: 読み込めないファイル、ディレクトリがあります: %v", dir, runner.errs)
+	}
+	return runner.files, nil
+}
+
+// 同期するファイルの一覧を取得する
+type syncScanner struct {
+	mu    sync.Mutex
+	files map[string]SyncFile
+	errs  []string
+}
+
+func (r *syncScanner) Run(baseDir, srcDir string, job *JobStatus) error {
+	entries, err := os.ReadDir(filepath.Join(baseDir, srcDir))
+	if err != nil {
+		job.AddErrorDirs(srcDir)
+		return err
+	}
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+		relFile := filepath.Join(srcDir, entry.Name())
+		info, ok := selectFile(filepath.Join(baseDir, relFile), relFile, entry, job)
+		if !ok {
+			continue
+		}
+		if info == nil {
+			job.AddErrorFile(relFile)
+			continue
+		}
+		r.mu.Lock()
+		r.files[relFile] = SyncFile{Size: info.Size(), ModTime: info.ModTime()}
+		r.mu.Unlock()
+	}
+	return nil
+}
+
+func (r *syncScanner) Retry(srcDir, targetFile string) error {
+	return nil
+}
+
+func (r *syncScanner) Finish(baseDir string, dirs []string, job *JobStatus) error {
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	r.errs = append(append(r.errs, job.errorDirs...), job.errorFiles...)
+	return nil
+}
+
+// ファイルの状態を取得する
+func statSyncFile(path string) (SyncFile, bool) {
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return SyncFile{}, false
+	}
+	return SyncFile{Size: info.Size(), ModTime: info.ModTime()}, true
+}
+
+// ファイル一覧のパスをまとめる
+func union(lists ...map[string]SyncFile) map[string]bool {
+	paths := map[string]bool{}
+	for _, list := range lists {
+		for path := range list {
+			paths[path] = true
+		}
+	}
+	return paths
+}
+
+// 同期結果を出力する
+func (report *SyncReport) WriteText(w io.Writer) error {
+	lists := []struct {
+		label string
+		paths []string
+	}{
+		{"A -> B", report.CopyAToB},
+		{"A <- B", report.CopyBToA},
+		{"DELETE A", report.DeleteA},
+		{"DELETE B", report.DeleteB},
+		{"ERROR", report.Errors},
+	}
+	for _, list := range lists {
+		for _, path := range list.paths {
+			_, err := fmt.Fprintf(w, "%-10s %s\n", list.label, path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for _, c := range report.Conflicts {
+		line := fmt.Sprintf("%-10s %s (%s", "CONFLICT", c.Path, c.Resolution)
+		if c.Kept != "" {
+			line += ", " + c.Kept + " を残す"
+		}
+		if c.Renamed != "" {
+			line += ", " + c.Renamed
+		}
+		_, err := fmt.Fprintln(w, line+")")
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coco-papiyon/mechacopy/filecopy"
+)
+
+// マニフェストのファイル情報
+type ManifestEntry struct {
+	Path    string    `json:"path"`
+	SHA256  string    `json:"sha256"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+}
+
+// マニフェストとの検証結果
+type VerifyReport struct {
+	Missing   []string `json:"missing"`   // マニフェストにあり、ファイルがない
+	Extra     []string `json:"extra"`     // マニフェストにないファイル
+	Corrupted []string `json:"corrupted"` // ハッシュ値が異なる
+	Errors    []string `json:"errors"`    // 読み込めなかったファイル、ディレクトリ
+}
+
+// 問題がないかチェックする
+func (report VerifyReport) OK() bool {
+	return len(report.Missing) == 0 && len(report.Extra) == 0 &&
+		len(report.Corrupted) == 0 && len(report.Errors) == 0
+}
+
+// ファイルのハッシュ値を取得してマニフェストを作成、または検証する
+type ChecksumRunner struct {
+	Expected      map[string]string // 検証するマニフェスト(パス -> ハッシュ値)。nilの場合は作成のみ
+	ManifestFiles []string          // マニフェストファイル(対象ディレクトリ内にある場合もハッシュ値を取得しない)
+
+	mu        sync.Mutex
+	entries   []ManifestEntry
+	skipped   map[string]bool // 対象外(パターン、サイズ、更新日時)のファイル
+	report    VerifyReport
+	once      sync.Once
+	manifests map[string]bool // マニフェストファイルの絶対パス
+}
+
+// ディレクトリ内のファイルのハッシュ値を取得する(サブディレクトリは無視)
+func (r *ChecksumRunner) Run(baseDir, srcDir string, job *JobStatus) error {
+	target := filepath.Join(baseDir, srcDir)
+
+	// ディレクトリ内のファイル一覧を取得
+	entries, err := os.ReadDir(target)
+	if err != nil {
+		job.AddErrorDirs(srcDir)
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		relFile := filepath.Join(srcDir, entry.Name())
+		if r.isManifest(filepath.Join(target, entry.Name())) {
+			continue
+		}
+		info, ok := selectFile(filepath.Join(target, entry.Name()), relFile, entry, job)
+		if !ok {
+			r.skip(relFile)
+			continue
+		}
+		if info == nil {
+			job.AddErrorFile(relFile)
+			continue
+		}
+
+		hash, err := filecopy.HashFile(filepath.Join(target, entry.Name()))
+		if err != nil {
+			slog.Error("File Hash", "file", relFile, "ERROR", err)
+			job.AddErrorFile(relFile)
+			continue
+		}
+		r.add(ManifestEntry{
+			Path:    filepath.ToSlash(relFile),
+			SHA256:  hash,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+		job.AddSuccessFile()
+	}
+	return nil
+}
+
+func (r *ChecksumRunner) add(entry ManifestEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, entry)
+}
+
+func (r *ChecksumRunner) skip(relFile string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.skipped == nil {
+		r.skipped = map[string]bool{}
+	}
+	r.skipped[filepath.ToSlash(relFile)] = true
+}
+
+// マニフェストファイルかチェックする
+func (r *ChecksumRunner) isManifest(file string) bool {
+	r.once.Do(func() {
+		r.manifests = map[string]bool{}
+		for _, manifest := range r.ManifestFiles {
+			if abs, err := filepath.Abs(manifest); err == nil {
+				r.manifests[abs] = true
+			}
+		}
+	})
+	if len(r.manifests) == 0 {
+		return false
+	}
+	abs, err := filepath.Abs(file)
+	return err == nil && r.manifests[abs]
+}
+
+// マニフェストのパスが対象外(パターン、サイズ、更新日時、除外ディレクトリ、階層数)かチェックする
+func (r *ChecksumRunner) isExcluded(path string, job *JobStatus, loaded map[string]bool) bool {
+	if r.skipped[path] {
+		return true
+	}
+	relFile := filepath.FromSlash(path)
+	dir := filepath.Dir(relFile)
+	if job.config.MaxDepth > 0 && dir != "." && strings.Count(dir, string(filepath.Separator))+2 > job.config.MaxDepth {
+		return true
+	}
+	return isExcludedDir(job.filter, dir, loaded) || !job.filter.IsCopyFile(relFile)
+}
+
+// ハッシュ値の取得はリトライしない
+func (r *ChecksumRunner) Retry(srcDir, targetFile string) error {
+	return nil
+}
+
+// マニフェストと比較する
+func (r *ChecksumRunner) Finish(baseDir string, dirs []string, job *JobStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job.mu.Lock()
+	r.report.Errors = append(append([]string{}, job.errorDirs...), job.errorFiles...)
+	job.mu.Unlock()
+	sort.Slice(r.entries, func(i, j int) bool {
+		return r.entries[i].Path < r.entries[j].Path
+	})
+	if r.Expected == nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	for _, entry := range r.entries {
+		seen[entry.Path] = true
+		hash, ok := r.Expected[entry.Path]
+		switch {
+		case !ok:
+			r.report.Extra = append(r.report.Extra, entry.Path)
+		case !strings.EqualFold(hash, entry.SHA256):
+			r.report.Corrupted = append(r.report.Corrupted, entry.Path)
+		}
+	}
+	errFiles := map[string]bool{}
+	for _, file := range r.report.Errors {
+		errFiles[filepath.ToSlash(file)] = true
+	}
+	loaded := map[string]bool{}
+	for path := range r.Expected {
+		if !seen[path] && !errFiles[path] && !r.isExcluded(path, job, loaded) {
+			r.report.Missing = append(r.report.Missing, path)
+		}
+	}
+	sort.Strings(r.report.Missing)
+	return nil
+}
+
+// 作成したマニフェストを取得する(パス順)
+func (r *ChecksumRunner) Entries() []ManifestEntry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]ManifestEntry{}, r.entries...)
+}
+
+// 検証結果を取得する
+func (r *ChecksumRunner) Report() VerifyReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.report
+}
+
+// sha256sum のファイル名のエスケープ
+var (
+	manifestEscaper   = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`)
+	manifestUnescaper = strings.NewReplacer(`\\`, `\`, `\n`, "\n", `\r`, "\r")
+)
+
+// sha256sum 互換の形式でマニフェストを出力する
+// ('\'、改行を含むファイル名は sha256sum と同じく行頭に '\' を付けてエスケープする)
+func WriteManifest(w io.Writer, entries []ManifestEntry) error {
+	for _, entry := range entries {
+		prefix := ""
+		path := entry.Path
+		if strings.ContainsAny(path, "\\\n\r") {
+			prefix = `\`
+			path = manifestEscaper.Replace(path)
+		}
+		_, err := fmt.Fprintf(w, "%s%s  %s\n", prefix, entry.SHA256, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// JSON形式でマニフェストを出力する
+func WriteManifestJSON(w io.Writer, entries []ManifestEntry) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(entries)
+}
+
+// マニフェストを読み込む(sha256sum 形式、JSON形式)
+func ReadManifest(r io.Reader) (map[string]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	manifest := map[string]string{}
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		entries := []ManifestEntry{}
+		err := json.Unmarshal(trimmed, &entries)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			manifest[entry.Path] = entry.SHA256
+		}
+		return manifest, nil
+	}
+
+	// "ハッシュ値  パス" または "ハッシュ値 *パス"(バイナリモード)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		escaped := strings.HasPrefix(line, `\`)
+		if escaped {
+			line = line[1:]
+		}
+		hash, path, ok := strings.Cut(line, " ")
+		if !ok || len(path) < 2 || (path[0] != ' ' && path[0] != '*') {
+			return nil, fmt.Errorf("line %d: 不正な形式です", lineNo)
+		}
+		path = path[1:]
+		if escaped {
+			path = manifestUnescaper.Replace(path)
+		}
+		manifest[strings.TrimPrefix(path, "./")] = hash
+	}
+	return manifest, scanner.Err()
+}
+
+// 検証結果を出力する
+func (report VerifyReport) WriteText(w io.Writer) error {
+	lists := []struct {
+		label string
+		paths []string
+	}{
+		{"MISSING", report.Missing},
+		{"EXTRA", report.Extra},
+		{"FAILED", report.Corrupted},
+		{"ERROR", report.Errors},
+	}
+	for _, list := range lists {
+		for _, path := range list.paths {
+			_, err := fmt.Fprintf(w, "%-8s %s\n", list.label, path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -23,6 +23,13 @@ func (r CopyRunner) Retry(srcDir, targetFile string) error {
 	return filecopy.CopyFile(src, dst)
 }
 
+// 指定したファイルをコピーする(ファイル一覧を指定した場合)
+func (r CopyRunner) RunFile(baseDir, relFile string, entry os.DirEntry, job *JobStatus) {
+	srcFile := filepath.Join(baseDir, relFile)
+	dstFile := filepath.Join(r.Destination, relFile)
+	copyFile(srcFile, dstFile, relFile, entry, job)
+}
+
 // ディレクトリ内のファイルをコピーする関数（サブディレクトリは無視）
 func copyFiles(baseDir, srcBaseDir, dstDir string, job *JobStatus) error {
 	srcDir := filepath.Join(baseDir, srcBaseDir)
@@ -30,7 +37,7 @@ func copyFiles(baseDir, srcBaseDir, dstDir string, job *JobStatus) error {
 	// ディレクトリ内のファイル一覧を取得
 	entries, err := os.ReadDir(srcDir)
 	if err != nil {
-		job.AddErrorDirs(srcDir)
+		job.AddErrorDirs(srcBaseDir)
 		return err
 	}
 
@@ -39,51 +46,113 @@ func copyFiles(baseDir, srcBaseDir, dstDir string, job *JobStatus) error {
 			// ファイルのみをコピーする
 			srcFile := filepath.Join(srcDir, entry.Name())
 			dstFile := filepath.Join(dstDir, entry.Name())
+			relFile := filepath.Join(srcBaseDir, entry.Name())
+			copyFile(srcFile, dstFile, relFile, entry, job)
+		}
+	}
 
-			// コピー対象のファイルではない場合はスキップする
-			if !filecopy.IsCopyFile(srcFile, job.config.TargetFiles) {
-				job.AddSkipFile()
-				continue
-			}
+	return nil
+}
 
-			// 差分がない場合はコピーしない(サイズ、更新日付
-			diff := filecopy.IsFileDiff(srcFile, dstFile)
-			if !diff {
-				job.AddSkipFile()
-				continue
-			}
+// ファイルをコピーする(スキップ、エラーは集計結果に記録する)
+func copyFile(srcFile, dstFile, relFile string, entry os.DirEntry, job *JobStatus) {
+	// コピー対象のファイルではない場合はスキップする
+	srcInfo, ok := selectFile(srcFile, relFile, entry, job)
+	if !ok {
+		return
+	}
+	copyTarget(srcFile, dstFile, relFile, srcInfo, job)
+}
 
-			// 元ファイルの情報取得
-			var size int64 = 0
-			srcInfo, err := os.Stat(srcFile)
-			if err == nil {
-				if srcInfo.Size() > 1073741824 {
-					size = srcInfo.Size() / 1073741824
-				}
-			}
+// コピー先への書き込み結果
+type copyResult int
 
-			// ファイルサイズが大きい場合はログ出力
-			if size > 0 {
-				slog.Info("START COPY BIG FILE", "file", srcFile, "size(GB)", size)
-			}
+const (
+	copyNone   copyResult = iota // 書き込んでいない(差分なし、上書きしない設定、エラー)
+	copyDone                     // コピーした
+	copyLinked                   // 前回のスナップショットへのハードリンクを作成した
+)
 
-			// ファイルコピー
-			err = filecopy.CopyFile(srcFile, dstFile)
-			if err != nil {
-				slog.Error("File Copy", "file", srcFile, "ERROR", err)
-				errFile := filepath.Join(srcBaseDir, entry.Name())
-				job.AddErrorFile(errFile)
-				continue
-			}
+// コピー対象のファイルをコピーする(差分、上書きの設定をチェックする)
+func copyTarget(srcFile, dstFile, relFile string, srcInfo os.FileInfo, job *JobStatus) copyResult {
+	// 差分がない場合、上書きしない場合はコピーしない(サイズ、更新日付
+	diff := filecopy.CompareFile(srcFile, dstFile)
+	if reason, skip := skipOverwrite(diff, job.config); skip {
+		slog.Debug("Skip File", "file", srcFile, "reason", reason)
+		job.AddSkipFile(reason)
+		return copyNone
+	}
 
-			// ファイルサイズが大きい場合はログ出力
-			if size > 0 {
-				slog.Info("END COPY BIG FILE", "file", srcFile, "size(GB)", size)
-			}
+	// 上書きする場合はコピー先ファイルを退避する
+	if job.backup != nil && diff != filecopy.DiffMissing {
+		err := job.backup.Save(dstFile, filepath.Join(job.prefix, relFile))
+		if err != nil {
+			slog.Error("Backup File", "file", dstFile, "ERROR", err)
+			job.AddBackupErrorFile(relFile)
+			return copyNone
+		}
+	}
+	return writeTarget(srcFile, dstFile, relFile, srcInfo, job)
+}
 
-			job.AddSuccessFile()
+// コピー先にファイルを書き込む(前回のスナップショットと差分がない場合はハードリンク)
+func writeTarget(srcFile, dstFile, relFile string, srcInfo os.FileInfo, job *JobStatus) copyResult {
+	// 元ファイルの情報取得
+	var size int64 = 0
+	if srcInfo != nil && srcInfo.Size() > 1073741824 {
+		size = srcInfo.Size() / 1073741824
+	}
+
+	// 前回のスナップショットと差分がない場合はハードリンクを作成する
+	if job.config.LinkDest != "" {
+		prevFile := filepath.Join(job.config.LinkDest, job.prefix, relFile)
+		if !filecopy.IsFileDiff(srcFile, prevFile) {
+			err := filecopy.LinkFile(prevFile, dstFile)
+			if err == nil {
+				job.AddLinkFile()
+				return copyLinked
+			}
+			slog.Warn("Link File", "file", prevFile, "ERROR", err)
 		}
 	}
 
-	return nil
+	// ファイルサイズが大きい場合はログ出力
+	if size > 0 {
+		slog.Info("START COPY BIG FILE", "file", srcFile, "size(GB)", size)
+	}
+
+	// ファイルコピー
+	err := filecopy.CopyFile(srcFile, dstFile)
+	if err != nil {
+		slog.Error("File Copy", "file", srcFile, "ERROR", err)
+		job.AddErrorFile(relFile)
+		return copyNone
+	}
+
+	// ファイルサイズが大きい場合はログ出力
+	if size > 0 {
+		slog.Info("END COPY BIG FILE", "file", srcFile, "size(GB)", size)
+	}
+
+	job.AddSuccessFile()
+	return copyDone
+}
+
+// 上書きの設定に合わせてスキップするかチェックする
+func skipOverwrite(diff filecopy.FileDiff, config *Config) (SkipReason, bool) {
+	switch {
+	case diff == filecopy.DiffSame:
+		return SkipSame, true
+	case diff == filecopy.DiffMissing || diff == filecopy.DiffUnknown:
+		return "", false
+	case config.NoOverwrite:
+		return SkipExist, true
+	case diff == filecopy.DiffOlder && config.ExcludeOlder:
+		return SkipOlder, true
+	case diff == filecopy.DiffNewer && config.ExcludeNewer:
+		return SkipNewer, true
+	case diff == filecopy.DiffChanged && config.ExcludeChanged:
+		return SkipChanged, true
+	}
+	return "", false
 }
@@ -1,24 +1,141 @@
 package worker
 
 import (
+	"errors"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/coco-papiyon/mechacopy/filecopy"
+	"github.com/coco-papiyon/mechacopy/trash"
 )
 
 type DeleteRunner struct {
+	RemoveEmptyDirs bool           // ファイルを削除していない空ディレクトリも削除する
+	Trash           *trash.Trash   // 指定した場合は削除せずにゴミ箱へ移動する
+	Wipe            *filecopy.Wipe // 指定した場合は内容を上書きしてから削除する
+
+	mu      sync.Mutex
+	touched map[string]bool // ファイル、子ディレクトリを削除したディレクトリ
+	failed  int             // 削除できなかったファイル、ディレクトリの数
 }
 
-func (r DeleteRunner) Run(baseDir, srcDir string, job *JobStatus) error {
+// ディレクトリ内の対象ファイルを削除する(サブディレクトリは無視)
+func (r *DeleteRunner) Run(baseDir, srcDir string, job *JobStatus) error {
 	target := filepath.Join(baseDir, srcDir)
-	err := os.RemoveAll(target)
+
+	// ディレクトリ内のファイル一覧を取得
+	entries, err := os.ReadDir(target)
 	if err != nil {
-		slog.Error("Delete Directory", "directory", target, "ERROR", err)
-		//job.AddErrorFile(target)
+		job.AddErrorDirs(srcDir)
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		// 削除対象のファイルではない場合はスキップする
+		relFile := filepath.Join(srcDir, entry.Name())
+		if _, ok := selectFile(filepath.Join(target, entry.Name()), relFile, entry, job); !ok {
+			continue
+		}
+
+		// ファイル削除
+		file := filepath.Join(target, entry.Name())
+		err := r.remove(file, relFile)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Error("Delete File", "file", file, "ERROR", err)
+			job.AddErrorFile(relFile)
+			if r.Wipe != nil {
+				job.AddWipeErrorFile(relFile)
+			}
+			continue
+		}
+		r.touch(srcDir)
+		job.AddSuccessFile()
 	}
 	return nil
 }
 
-func (r DeleteRunner) Retry(srcDir, targetFile string) error {
+func (r *DeleteRunner) Retry(srcDir, targetFile string) error {
+	err := r.remove(filepath.Join(srcDir, targetFile), targetFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	r.touch(filepath.Dir(targetFile))
+	return nil
+}
+
+// ファイルを削除する(ゴミ箱を指定した場合はゴミ箱へ移動する)
+func (r *DeleteRunner) remove(file, relFile string) error {
+	if r.Trash != nil {
+		return r.Trash.Put(file, relFile)
+	}
+	if r.Wipe != nil {
+		return r.Wipe.Remove(file)
+	}
+	return os.Remove(file)
+}
+
+// 空になったディレクトリを深い階層から順に削除する(起点ディレクトリは削除しない)
+func (r *DeleteRunner) Finish(baseDir string, dirs []string, job *JobStatus) error {
+	sorted := make([]string, len(dirs))
+	copy(sorted, dirs)
+	sort.Sort(sort.Reverse(sort.StringSlice(sorted)))
+
+	for _, dir := range sorted {
+		// 起点ディレクトリは削除しない
+		if dir == "." || (!r.RemoveEmptyDirs && !r.isTouched(dir)) {
+			continue
+		}
+
+		// 空でないディレクトリは削除しない
+		target := filepath.Join(baseDir, dir)
+		entries, err := os.ReadDir(target)
+		if err != nil || len(entries) > 0 {
+			continue
+		}
+		err = os.Remove(target)
+		if err != nil {
+			slog.Error("Delete Directory", "directory", target, "ERROR", err)
+			job.AddErrorDirs(dir)
+			continue
+		}
+		slog.Info("Delete Directory", "directory", target)
+		r.touch(filepath.Dir(dir))
+	}
+
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failed = len(job.errorFiles) + len(job.errorDirs)
 	return nil
 }
+
+// 削除できなかったファイル、ディレクトリの数を取得する(リトライ後)
+func (r *DeleteRunner) Failed() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.failed
+}
+
+// ファイル、子ディレクトリを削除したディレクトリを記録する
+func (r *DeleteRunner) touch(dir string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.touched == nil {
+		r.touched = map[string]bool{}
+	}
+	r.touched[filepath.Clean(dir)] = true
+}
+
+func (r *DeleteRunner) isTouched(dir string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.touched[filepath.Clean(dir)]
+}
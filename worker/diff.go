@@ -0,0 +1,268 @@
+package worker
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coco-papiyon/mechacopy/filecopy"
+)
+
+// 差分の種類
+type DiffKind string
+
+const (
+	DiffOnlySource      DiffKind = "only_source"      // コピー元のみに存在する
+	DiffOnlyDestination DiffKind = "only_destination" // コピー先のみに存在する
+	DiffSize            DiffKind = "size"             // サイズが異なる
+	DiffModTime         DiffKind = "mtime"            // 更新日時が異なる
+	DiffContent         DiffKind = "content"          // 内容(ハッシュ値)が異なる
+)
+
+// ファイルの差分
+type DiffEntry struct {
+	Path    string     `json:"path"`
+	Kind    DiffKind   `json:"kind"`
+	SrcSize int64      `json:"src_size,omitempty"`
+	DstSize int64      `json:"dst_size,omitempty"`
+	SrcTime *time.Time `json:"src_mtime,omitempty"`
+	DstTime *time.Time `json:"dst_mtime,omitempty"`
+}
+
+// 比較結果
+type DiffReport struct {
+	Match       bool        `json:"match"`
+	Differences []DiffEntry `json:"differences"`
+	Errors      []string    `json:"errors"`
+}
+
+// 2つのディレクトリを比較する(コピーはしない)
+type DiffRunner struct {
+	Destination string
+	Hash        bool // サイズ、更新日時が同じ場合も内容を比較する
+
+	mu     sync.Mutex
+	report DiffReport
+}
+
+// ディレクトリ内のファイルを比較する(サブディレクトリは無視)
+func (r *DiffRunner) Run(baseDir, srcDir string, job *JobStatus) error {
+	srcPath := filepath.Join(baseDir, srcDir)
+	dstPath := filepath.Join(r.Destination, srcDir)
+
+	// ディレクトリ内のファイル一覧を取得
+	srcEntries, err := os.ReadDir(srcPath)
+	if err != nil {
+		job.AddErrorDirs(srcDir)
+		return err
+	}
+	dstEntries, err := os.ReadDir(dstPath)
+	if err != nil && !os.IsNotExist(err) {
+		job.AddErrorDirs(srcDir)
+		return err
+	}
+	dstFiles := map[string]os.DirEntry{}
+	for _, entry := range dstEntries {
+		dstFiles[entry.Name()] = entry
+	}
+
+	srcNames := map[string]bool{}
+	for _, entry := range srcEntries {
+		srcNames[entry.Name()] = entry.IsDir()
+		if entry.IsDir() {
+			continue
+		}
+		relFile := filepath.Join(srcDir, entry.Name())
+		srcInfo, ok := selectFile(filepath.Join(srcPath, entry.Name()), relFile, entry, job)
+		if !ok {
+			continue
+		}
+		if srcInfo == nil {
+			job.AddErrorFile(relFile)
+			continue
+		}
+
+		dstEntry, exist := dstFiles[entry.Name()]
+		if !exist || dstEntry.IsDir() {
+			r.add(DiffEntry{Path: relFile, Kind: DiffOnlySource, SrcSize: srcInfo.Size(), SrcTime: timePtr(srcInfo.ModTime())})
+			continue
+		}
+		dstInfo, err := entryInfo(filepath.Join(dstPath, entry.Name()), dstEntry)
+		if err != nil {
+			job.AddErrorFile(relFile)
+			continue
+		}
+
+		// ファイルを比較
+		kind, err := r.compare(filepath.Join(srcPath, entry.Name()), filepath.Join(dstPath, entry.Name()), srcInfo, dstInfo)
+		if err != nil {
+			slog.Error("File Compare", "file", relFile, "ERROR", err)
+			job.AddErrorFile(relFile)
+			continue
+		}
+		if kind == "" {
+			job.AddSuccessFile()
+			continue
+		}
+		r.add(DiffEntry{
+			Path:    relFile,
+			Kind:    kind,
+			SrcSize: srcInfo.Size(),
+			DstSize: dstInfo.Size(),
+			SrcTime: timePtr(srcInfo.ModTime()),
+			DstTime: timePtr(dstInfo.ModTime()),
+		})
+	}
+
+	// コピー先のみに存在するファイル、ディレクトリ
+	for _, entry := range dstEntries {
+		isDir, exist := srcNames[entry.Name()]
+		relFile := filepath.Join(srcDir, entry.Name())
+		switch {
+		case entry.IsDir() && (!exist || !isDir):
+			if !job.filter.IsExcludeDir(relFile) {
+				r.addOnlyDestination(relFile, job)
+			}
+		case !entry.IsDir() && (!exist || isDir):
+			if info, ok := selectFile(filepath.Join(dstPath, entry.Name()), relFile, entry, job); ok {
+				diff := DiffEntry{Path: relFile, Kind: DiffOnlyDestination}
+				if info != nil {
+					diff.DstSize = info.Size()
+					diff.DstTime = timePtr(info.ModTime())
+				}
+				r.add(diff)
+			}
+		}
+	}
+	return nil
+}
+
+// ファイルを比較する(差分がない場合は空文字)
+// (サイズ、更新日時はコピーと同じ基準で比較し、ハッシュ値の比較のみ追加する)
+func (r *DiffRunner) compare(src, dst string, srcInfo, dstInfo os.FileInfo) (DiffKind, error) {
+	if srcInfo.Size() != dstInfo.Size() {
+		return DiffSize, nil
+	}
+	if r.Hash {
+		srcHash, err := filecopy.HashFile(src)
+		if err != nil {
+			return "", err
+		}
+		dstHash, err := filecopy.HashFile(dst)
+		if err != nil {
+			return "", err
+		}
+		if srcHash != dstHash {
+			return DiffContent, nil
+		}
+	}
+	if filecopy.CompareFileInfo(srcInfo.Size(), srcInfo.ModTime(), dstInfo) != filecopy.DiffSame {
+		return DiffModTime, nil
+	}
+	return "", nil
+}
+
+// コピー先のみに存在するディレクトリ内のファイルを記録する
+func (r *DiffRunner) addOnlyDestination(relDir string, job *JobStatus) {
+	root := filepath.Join(r.Destination, relDir)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(r.Destination, path)
+		if d.IsDir() {
+			if path != root && job.filter.IsExcludeDir(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, ok := selectFile(path, rel, d, job)
+		if !ok {
+			return nil
+		}
+		diff := DiffEntry{Path: rel, Kind: DiffOnlyDestination}
+		if info != nil {
+			diff.DstSize = info.Size()
+			diff.DstTime = timePtr(info.ModTime())
+		}
+		r.add(diff)
+		return nil
+	})
+	if err != nil {
+		slog.Error("Directory Compare", "directory", root, "ERROR", err)
+		job.AddErrorDirs(relDir)
+	}
+}
+
+func (r *DiffRunner) add(diff DiffEntry) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.Differences = append(r.report.Differences, diff)
+}
+
+// 比較できなかったファイル、ディレクトリを記録する
+func (r *DiffRunner) Finish(baseDir string, dirs []string, job *JobStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	r.report.Errors = append(r.report.Errors, job.errorDirs...)
+	r.report.Errors = append(r.report.Errors, job.errorFiles...)
+	return nil
+}
+
+// 比較はリトライしない
+func (r *DiffRunner) Retry(srcDir, targetFile string) error {
+	return nil
+}
+
+// 比較結果を取得する(パス順)
+func (r *DiffRunner) Report() DiffReport {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := DiffReport{
+		Differences: append([]DiffEntry{}, r.report.Differences...),
+		Errors:      append([]string{}, r.report.Errors...),
+	}
+	sort.Slice(report.Differences, func(i, j int) bool {
+		return report.Differences[i].Path < report.Differences[j].Path
+	})
+	sort.Strings(report.Errors)
+	report.Match = len(report.Differences) == 0 && len(report.Errors) == 0
+	return report
+}
+
+// 比較結果をテキスト形式で出力する
+func (report DiffReport) WriteText(w io.Writer) error {
+	for _, diff := range report.Differences {
+		_, err := fmt.Fprintf(w, "%-16s %s\n", diff.Kind, diff.Path)
+		if err != nil {
+			return err
+		}
+	}
+	for _, path := range report.Errors {
+		_, err := fmt.Fprintf(w, "%-16s %s\n", "error", path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 比較結果をJSON形式で出力する
+func (report DiffReport) WriteJSON(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(report)
+}
+
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
@@ -0,0 +1,113 @@
+package worker
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/coco-papiyon/mechacopy/filecopy"
+)
+
+// 空ディレクトリの判定で無視する既定のファイル
+var DefaultJunkFiles = []string{"Thumbs.db", "desktop.ini", ".DS_Store", "._*"}
+
+// 空のディレクトリを深い階層から順に削除する(起点ディレクトリは削除しない)
+// junkFilesに一致するファイルのみを含むディレクトリは、ファイルを削除して空ディレクトリとして扱う
+func RemoveEmptyDirs(srcDir string, config *Config, junkFiles []string) (int, []string, error) {
+	removed := 0
+	errDirs, err := walkEmptyDirs(srcDir, config, junkFiles, func(dir, target string, entries []os.DirEntry) bool {
+		// 無視するファイルを削除してからディレクトリを削除
+		var err error
+		for _, entry := range entries {
+			err = os.Remove(filepath.Join(target, entry.Name()))
+			if err != nil {
+				break
+			}
+		}
+		if err == nil {
+			err = os.Remove(target)
+		}
+		if err != nil {
+			slog.Error("Delete Directory", "directory", target, "ERROR", err)
+			return false
+		}
+		slog.Info("Delete Directory", "directory", target)
+		removed++
+		return true
+	})
+	return removed, errDirs, err
+}
+
+// 削除対象の空ディレクトリ数と、一緒に削除する無視するファイル数を数える(削除はしない)
+func CountEmptyDirs(srcDir string, config *Config, junkFiles []string) (int64, int64, error) {
+	var dirs, files int64
+	_, err := walkEmptyDirs(srcDir, config, junkFiles, func(dir, target string, entries []os.DirEntry) bool {
+		dirs++
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files++
+			}
+		}
+		return true
+	})
+	return dirs, files, err
+}
+
+// 空のディレクトリを深い階層から順に処理する(処理したディレクトリのみを含む親ディレクトリも空として扱う)
+// fnがfalseを返したディレクトリはエラーとして記録する
+func walkEmptyDirs(srcDir string, config *Config, junkFiles []string, fn func(dir, target string, entries []os.DirEntry) bool) ([]string, error) {
+	filter, err := newFilter(srcDir, config)
+	if err != nil {
+		return nil, err
+	}
+	junk, err := filecopy.NewMatcher(junkFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	// 除外ディレクトリを除いたディレクトリ一覧(深い階層から順)
+	walker := newWalker(config, filter)
+	dirs, err := walker.GetDirs(srcDir)
+	if err != nil {
+		return nil, err
+	}
+	errDirs := []string{}
+	for _, dirErr := range walker.Errors() {
+		errDirs = append(errDirs, dirErr.Path)
+	}
+
+	done := map[string]bool{}
+	for _, dir := range dirs {
+		if dir == "." {
+			continue
+		}
+		target := filepath.Join(srcDir, dir)
+		entries, err := os.ReadDir(target)
+		if err != nil {
+			errDirs = append(errDirs, dir)
+			continue
+		}
+		if !isEmptyDir(dir, entries, junk, done) {
+			continue
+		}
+		if !fn(dir, target, entries) {
+			errDirs = append(errDirs, dir)
+			continue
+		}
+		done[dir] = true
+	}
+	return errDirs, nil
+}
+
+// 無視するファイル、処理済みのディレクトリ以外を含まないディレクトリかチェックする
+func isEmptyDir(dir string, entries []os.DirEntry, junk *filecopy.Matcher, done map[string]bool) bool {
+	for _, entry := range entries {
+		if entry.IsDir() && done[filepath.Join(dir, entry.Name())] {
+			continue
+		}
+		if !entry.Type().IsRegular() || !junk.Match(entry.Name()) {
+			return false
+		}
+	}
+	return true
+}
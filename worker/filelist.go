@@ -0,0 +1,185 @@
+package worker
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/coco-papiyon/mechacopy/filecopy"
+)
+
+// ファイル単位で処理できるRunner(ファイル一覧を指定した場合)
+type FileRunner interface {
+	Runner
+	RunFile(string, string, os.DirEntry, *JobStatus)
+}
+
+// ファイル一覧を読み込む(改行区切り、NUL文字を含む場合はNUL区切り)
+func ReadFileList(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	sep := byte('\n')
+	if bytes.IndexByte(data, 0) >= 0 {
+		sep = 0
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(data)+1)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, sep); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+
+	files := []string{}
+	for scanner.Scan() {
+		file := scanner.Text()
+		if sep == '\n' {
+			file = strings.TrimRight(file, "\r")
+		}
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, scanner.Err()
+}
+
+// 指定したファイルのみを処理する(ディレクトリは走査しない)
+// ファイルはコピー元からの相対パス、またはコピー元以下の絶対パスで指定する
+func RunFiles(srcDir string, files []string, runner FileRunner, config *Config) error {
+	start := time.Now()
+
+	// コピー対象ファイルの判定
+	filter, err := newFilter(srcDir, config)
+	if err != nil {
+		slog.Error("ファイルパターン", "ERROR", err)
+		return err
+	}
+
+	// 同時実行用の制御
+	job := newJob(config, filter)
+
+	// 指定した数スレッド(goroutine)を起動
+	for i := 0; i < config.CopyThread; i++ {
+		go fileWorker(srcDir, runner, job, job.ch)
+	}
+
+	// コピー対象のファイルを送信
+	seen := map[string]bool{}
+	loaded := map[string]bool{}
+	for _, file := range files {
+		relFile, err := relPath(srcDir, file)
+		if err != nil {
+			slog.Error("File List", "file", file, "ERROR", err)
+			job.AddListErrorFile(file)
+			continue
+		}
+		if relFile == "." || seen[relFile] {
+			continue
+		}
+		seen[relFile] = true
+		if isExcludedDir(filter, filepath.Dir(relFile), loaded) {
+			job.AddSkipFile(SkipPattern)
+			continue
+		}
+		job.AddTotal()
+		job.wg.Add(1)
+		job.ch <- relFile
+	}
+	close(job.ch)
+
+	// 処理待ち
+	job.wg.Wait()
+
+	// エラーリトライ
+	if config.Retry {
+		runRetry(srcDir, runner, job)
+	}
+
+	// 一覧の誤り、存在しないファイルはリトライせずにエラーとして記録する
+	job.errorFiles = append(job.errorFiles, job.listErrorFiles...)
+
+	if !config.Quiet {
+		printSummary(start, job)
+	}
+	return nil
+}
+
+// コピー元からの相対パスを取得する(コピー元の外を指すパスはエラー)
+func relPath(srcDir, file string) (string, error) {
+	rel := filepath.Clean(file)
+	if filepath.IsAbs(rel) {
+		base, err := filepath.Abs(srcDir)
+		if err != nil {
+			return "", err
+		}
+		rel, err = filepath.Rel(base, rel)
+		if err != nil {
+			return "", err
+		}
+	}
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || filepath.IsAbs(rel) {
+		return "", fmt.Errorf("コピー元以下のファイルではありません: %s", file)
+	}
+	return rel, nil
+}
+
+// 除外ディレクトリ(またはその下位のディレクトリ)かチェックする
+// (上位ディレクトリから順にディレクトリごとのルールファイルを読み込む)
+func isExcludedDir(filter *filecopy.Filter, relDir string, loaded map[string]bool) bool {
+	dirs := []string{}
+	for dir := filepath.Clean(relDir); dir != "."; dir = filepath.Dir(dir) {
+		dirs = append([]string{dir}, dirs...)
+	}
+	for _, dir := range append([]string{"."}, dirs...) {
+		if !loaded[dir] {
+			loaded[dir] = true
+			if err := filter.LoadDir(dir); err != nil {
+				slog.Warn("ルールファイル", "directory", dir, "ERROR", err)
+			}
+		}
+		if dir != "." && filter.IsExcludeDir(dir) {
+			return true
+		}
+	}
+	return false
+}
+
+// 指定されたファイルを処理するワーカー(同時実行制御)
+func fileWorker(baseDir string, runner FileRunner, job *JobStatus, ch <-chan string) {
+	for relFile := range ch {
+		info, err := os.Lstat(filepath.Join(baseDir, relFile))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			slog.Error("File List", "file", relFile, "ERROR", err)
+			job.AddListErrorFile(relFile)
+			job.AddError()
+		case err != nil:
+			slog.Error("File List", "file", relFile, "ERROR", err)
+			job.AddErrorFile(relFile)
+			job.AddError()
+		case info.IsDir():
+			// ディレクトリは対象外(find の出力等に含まれる)
+			job.AddSuccess()
+		default:
+			runner.RunFile(baseDir, relFile, fs.FileInfoToDirEntry(info), job)
+			job.AddSuccess()
+			slog.Info(fmt.Sprintf("%s %s", job.GetStatus(), relFile))
+		}
+		job.wg.Done()
+	}
+}
@@ -4,14 +4,43 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+
+	"github.com/coco-papiyon/mechacopy/filecopy"
+)
+
+// ファイルをスキップした理由
+type SkipReason string
+
+const (
+	SkipPattern   SkipReason = "Pattern"   // 対象ファイルのパターンに一致しない
+	SkipSame      SkipReason = "Same"      // 差分がない
+	SkipSize      SkipReason = "Size"      // サイズが対象範囲外
+	SkipAge       SkipReason = "Age"       // 更新日時が対象範囲外
+	SkipOlder     SkipReason = "Older"     // コピー元の方が古い
+	SkipNewer     SkipReason = "Newer"     // コピー元の方が新しい
+	SkipChanged   SkipReason = "Changed"   // 更新日時が同じでサイズが異なる
+	SkipExist     SkipReason = "Exist"     // コピー先が存在する
+	SkipModified  SkipReason = "Modified"  // 計画後にコピー元が変更された
+	SkipCollision SkipReason = "Collision" // 先に指定したコピー元と同じコピー先になる
+	SkipDuplicate SkipReason = "Duplicate" // アーカイブ内の後のエントリと同じパス
 )
 
+// 集計結果に出力する順序
+var skipReasons = []SkipReason{
+	SkipPattern, SkipSame, SkipSize, SkipAge,
+	SkipOlder, SkipNewer, SkipChanged, SkipExist, SkipModified, SkipCollision,
+	SkipDuplicate,
+}
+
 type JobStatus struct {
 	mu sync.Mutex
 	wg sync.WaitGroup
 	ch chan string
 
 	config *Config
+	filter *filecopy.Filter
+	backup *filecopy.Backup
+	prefix string // コピー先のサブディレクトリ(複数のコピー元を統合する場合)
 
 	successCnt int32
 	errorCnt   int32
@@ -19,20 +48,45 @@ type JobStatus struct {
 
 	successFileCnt int32
 	skipFileCnt    int32
+	linkFileCnt    int32
+	skipCnt        map[SkipReason]int32
 
+	errorFiles     []string
+	errorDirs      []string
+	wipeErrorFiles []string
+	listErrorFiles []string
+	refusedFiles   []string
+	collisionFiles []string
+	backupErrors   []string // 退避できずに上書きしなかったファイル(リトライしない)
+
+	rowTitle string       // 集計の見出し
+	rows     []*rowStatus // コピー先、コピー元ごとの集計(複数の場合)
+}
+
+// コピー先、コピー元ごとの集計
+type rowStatus struct {
+	name       string
+	successCnt int32
+	skipCnt    int32
 	errorFiles []string
-	errorDirs  []string
+	errorCnt   int32 // errorFiles に記録しないエラー数
 }
 
 func (j *JobStatus) GetStatus() string {
 	successCnt := atomic.LoadInt32(&j.successCnt)
 	errCnt := atomic.LoadInt32(&j.errorCnt)
-	progress := float32(successCnt+errCnt) / float32(j.totalCnt) * 100
+	totalCnt := atomic.LoadInt32(&j.totalCnt)
+	progress := float32(successCnt+errCnt) / float32(totalCnt) * 100
 	return fmt.Sprintf("[%3d%%] %d/%d(%d)",
-		int(progress), successCnt+errCnt, j.totalCnt, errCnt,
+		int(progress), successCnt+errCnt, totalCnt, errCnt,
 	)
 }
 
+// 走査で見つかったディレクトリ数を加算する
+func (j *JobStatus) AddTotal() {
+	atomic.AddInt32(&j.totalCnt, 1)
+}
+
 func (j *JobStatus) AddSuccess() {
 	atomic.AddInt32(&j.successCnt, 1)
 }
@@ -45,8 +99,29 @@ func (j *JobStatus) AddSuccessFile() {
 	atomic.AddInt32(&j.successFileCnt, 1)
 }
 
-func (j *JobStatus) AddSkipFile() {
+// 前回のスナップショットへのハードリンク数を加算する
+func (j *JobStatus) AddLinkFile() {
+	atomic.AddInt32(&j.linkFileCnt, 1)
+}
+
+// 先に指定したコピー元で上書きしたファイルを、コピー数から同じコピー先のスキップ数に移す
+func (j *JobStatus) moveToCollision(result copyResult) {
+	if result == copyLinked {
+		atomic.AddInt32(&j.linkFileCnt, -1)
+	} else {
+		atomic.AddInt32(&j.successFileCnt, -1)
+	}
+	j.AddSkipFile(SkipCollision)
+}
+
+func (j *JobStatus) AddSkipFile(reason SkipReason) {
 	atomic.AddInt32(&j.skipFileCnt, 1)
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.skipCnt == nil {
+		j.skipCnt = map[SkipReason]int32{}
+	}
+	j.skipCnt[reason]++
 }
 
 func (j *JobStatus) AddErrorFile(file string) {
@@ -58,11 +133,89 @@ func (j *JobStatus) AddErrorFile(file string) {
 	j.errorFiles = append(j.errorFiles, file)
 }
 
+// 読み込めなかったディレクトリを記録する
 func (j *JobStatus) AddErrorDirs(file string) {
 	j.mu.Lock()
 	defer j.mu.Unlock()
 	if j.errorDirs == nil {
 		j.errorDirs = []string{}
 	}
-	j.errorFiles = append(j.errorDirs, file)
+	j.errorDirs = append(j.errorDirs, file)
+}
+
+// 上書きできなかったファイルを記録する(内容が残っている可能性がある)
+func (j *JobStatus) AddWipeErrorFile(file string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.wipeErrorFiles = append(j.wipeErrorFiles, file)
+}
+
+// ファイル一覧の誤りで処理できなかったファイルを記録する(リトライしない)
+func (j *JobStatus) AddListErrorFile(file string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.listErrorFiles = append(j.listErrorFiles, file)
+}
+
+// 計画後にコピー元が変更されたため操作しなかったファイルを記録する
+func (j *JobStatus) AddRefusedFile(file string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.refusedFiles = append(j.refusedFiles, file)
+}
+
+// 退避できなかったため上書きしなかったファイルを記録する
+// (リトライでは退避せずに上書きしてしまうため、エラーとは別に記録する)
+func (j *JobStatus) AddBackupErrorFile(file string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.backupErrors = append(j.backupErrors, file)
+}
+
+// 複数のコピー元で同じコピー先になったファイルを記録する
+func (j *JobStatus) AddCollisionFile(file string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.collisionFiles = append(j.collisionFiles, file)
+}
+
+// コピー先ごとの集計を作成する(作成済みの場合はそのまま)
+func (j *JobStatus) initDests(names []string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.rows != nil {
+		return
+	}
+	j.rowTitle = "Destination"
+	for _, name := range names {
+		j.rows = append(j.rows, &rowStatus{name: name})
+	}
+}
+
+func (j *JobStatus) AddDestSuccess(i int) {
+	atomic.AddInt32(&j.rows[i].successCnt, 1)
+}
+
+func (j *JobStatus) AddDestSkip(i int) {
+	atomic.AddInt32(&j.rows[i].skipCnt, 1)
+}
+
+func (j *JobStatus) AddDestError(i int, file string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.rows[i].errorFiles = append(j.rows[i].errorFiles, file)
+}
+
+// リトライで成功したファイルをエラーから除く
+func (j *JobStatus) RetryDestSuccess(i int, file string) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	dest := j.rows[i]
+	for k, errFile := range dest.errorFiles {
+		if errFile == file {
+			dest.errorFiles = append(dest.errorFiles[:k], dest.errorFiles[k+1:]...)
+			dest.successCnt++
+			return
+		}
+	}
 }
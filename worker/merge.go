@@ -0,0 +1,390 @@
+package worker
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coco-papiyon/mechacopy/directory"
+)
+
+var errNoSource = errors.New("コピー元が指定されていません")
+
+// 統合するコピー元
+type MergeSource struct {
+	Dir    string // コピー元ディレクトリ
+	Prefix string // コピー先のサブディレクトリ(空の場合はコピー先の直下)
+}
+
+// 複数のコピー元で同じコピー先になったファイル
+type MergeCollision struct {
+	Path    string // コピー先の相対パス
+	Kept    string // コピーしたコピー元(先に指定したコピー元)
+	Skipped string // コピーしなかったコピー元
+}
+
+// 共通のワーカーで処理するディレクトリ
+type mergeItem struct {
+	index int
+	dir   string
+}
+
+// 複数のコピー元を1つのコピー先に統合してコピーする
+// (ワーカーは共通、同じコピー先になるファイルは先に指定したコピー元を優先する)
+func RunMerge(sources []MergeSource, dstDir string, config *Config) ([]MergeCollision, error) {
+	start := time.Now()
+
+	// コピー元、コピー先のサブディレクトリを確認
+	if len(sources) == 0 {
+		return nil, errNoSource
+	}
+	prefixes := make([]string, len(sources))
+	for i, src := range sources {
+		info, err := os.Stat(src.Dir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("コピー元がディレクトリではありません: %s", src.Dir)
+		}
+		prefix := filepath.Clean(src.Prefix)
+		if filepath.IsAbs(prefix) || prefix == ".." || strings.HasPrefix(prefix, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("コピー先のサブディレクトリが不正です: %s", src.Prefix)
+		}
+		prefixes[i] = prefix
+	}
+
+	// コピー先が重なるコピー元のみ、コピー先のファイルを記録する
+	claims := &mergeClaims{files: map[string]*mergeClaim{}}
+	for _, src := range sources {
+		claims.names = append(claims.names, src.Dir)
+	}
+	runners := make([]*mergeRunner, len(sources))
+	claims.jobs = make([]*JobStatus, len(sources))
+	for i, src := range sources {
+		filter, err := newFilter(src.Dir, config)
+		if err != nil {
+			slog.Error("ファイルパターン", "ERROR", err)
+			return nil, err
+		}
+		job := newJob(config, filter)
+		job.prefix = prefixes[i]
+		claims.jobs[i] = job
+		runners[i] = &mergeRunner{
+			index:  i,
+			src:    src.Dir,
+			dst:    filepath.Join(dstDir, prefixes[i]),
+			prefix: prefixes[i],
+			job:    job,
+		}
+		for k := range sources {
+			if k != i && isOverlapDir(prefixes[i], prefixes[k]) {
+				runners[i].claims = claims
+				break
+			}
+		}
+	}
+
+	// 指定した数スレッド(goroutine)を起動(すべてのコピー元で共通)
+	total := newJob(config, nil)
+	ch := make(chan mergeItem)
+	for i := 0; i < config.CopyThread; i++ {
+		go mergeWorker(runners, total, ch)
+	}
+
+	// コピー元ごとに走査(見つけた順に共通のワーカーに送信)
+	walkers := make([]*directory.Walker, len(sources))
+	walkErrs := make([]error, len(sources))
+	var scan sync.WaitGroup
+	for i, r := range runners {
+		walkers[i] = newWalker(config, r.job.filter)
+		scan.Add(1)
+		go func(i int, r *mergeRunner) {
+			defer scan.Done()
+			dirCh := make(chan string, config.ScanThread)
+			go func() {
+				walkErrs[i] = walkers[i].Walk(r.src, dirCh)
+				close(dirCh)
+			}()
+			for name := range dirCh {
+				total.AddTotal()
+				total.wg.Add(1)
+				ch <- mergeItem{index: i, dir: name}
+			}
+		}(i, r)
+	}
+	scan.Wait()
+	close(ch)
+
+	// 処理待ち
+	total.wg.Wait()
+	for i, err := range walkErrs {
+		if err != nil {
+			slog.Error("ディレクトリ取得", "ERROR", err, "basePath", sources[i].Dir)
+			return nil, err
+		}
+	}
+
+	// 走査できなかったディレクトリを記録
+	for i, walker := range walkers {
+		for _, dirErr := range walker.Errors() {
+			runners[i].job.AddErrorDirs(dirErr.Path)
+		}
+	}
+
+	// エラーリトライ
+	if config.Retry {
+		for _, r := range runners {
+			runRetry(r.src, r, r.job)
+		}
+	}
+
+	collisions := claims.list()
+	if !config.Quiet {
+		printSummary(start, mergeSummary(runners, collisions))
+	}
+	return collisions, nil
+}
+
+// コピー元ごとの集計をまとめる
+func mergeSummary(runners []*mergeRunner, collisions []MergeCollision) *JobStatus {
+	summary := &JobStatus{rowTitle: "Source", skipCnt: map[SkipReason]int32{}}
+	for _, r := range runners {
+		job := r.job
+		summary.successFileCnt += job.successFileCnt
+		summary.linkFileCnt += job.linkFileCnt
+		summary.skipFileCnt += job.skipFileCnt
+		for reason, cnt := range job.skipCnt {
+			summary.skipCnt[reason] += cnt
+		}
+		for _, file := range job.errorFiles {
+			summary.errorFiles = append(summary.errorFiles, filepath.Join(r.src, file))
+		}
+		for _, file := range job.backupErrors {
+			summary.backupErrors = append(summary.backupErrors, filepath.Join(r.src, file))
+		}
+		for _, dir := range job.errorDirs {
+			summary.errorDirs = append(summary.errorDirs, filepath.Join(r.src, dir))
+		}
+
+		name := r.src
+		if r.prefix != "." {
+			name += " -> " + r.prefix
+		}
+		summary.rows = append(summary.rows, &rowStatus{
+			name:       name,
+			successCnt: job.successFileCnt + job.linkFileCnt,
+			skipCnt:    job.skipFileCnt,
+			errorCnt:   int32(len(job.errorFiles) + len(job.backupErrors)),
+		})
+	}
+	for _, c := range collisions {
+		summary.collisionFiles = append(summary.collisionFiles,
+			fmt.Sprintf("%s (%s を優先、%s はコピーしていません)", c.Path, c.Kept, c.Skipped))
+	}
+	return summary
+}
+
+// コピー先のサブディレクトリが重なるかチェックする
+func isOverlapDir(a, b string) bool {
+	return a == b || isParentDir(a, b) || isParentDir(b, a)
+}
+
+// 共通のワーカー(コピー元ごとのRunnerで処理する)
+func mergeWorker(runners []*mergeRunner, total *JobStatus, ch <-chan mergeItem) {
+	for item := range ch {
+		r := runners[item.index]
+		err := r.Run(r.src, item.dir, r.job)
+		if err != nil {
+			total.AddError()
+			slog.Error(fmt.Sprintf("Copy Error: %s %s %v", total.GetStatus(), filepath.Join(r.src, item.dir), err))
+		} else {
+			total.AddSuccess()
+			slog.Info(fmt.Sprintf("%s %s", total.GetStatus(), filepath.Join(r.src, item.dir)))
+		}
+		total.wg.Done()
+	}
+}
+
+// コピー元ごとのコピー
+type mergeRunner struct {
+	index  int
+	src    string
+	dst    string
+	prefix string
+	job    *JobStatus
+	claims *mergeClaims // コピー先が重ならない場合はnil
+}
+
+func (r *mergeRunner) Run(baseDir, srcDir string, job *JobStatus) error {
+	// ディレクトリ内のファイル一覧を取得
+	entries, err := os.ReadDir(filepath.Join(baseDir, srcDir))
+	if err != nil {
+		job.AddErrorDirs(srcDir)
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		relFile := filepath.Join(srcDir, entry.Name())
+		srcInfo, ok := selectFile(filepath.Join(baseDir, relFile), relFile, entry, job)
+		if !ok {
+			continue
+		}
+		r.copyFile(filepath.Join(baseDir, relFile), relFile, srcInfo, job)
+	}
+	return nil
+}
+
+// ファイルをコピーする(同じコピー先のファイルは先に指定したコピー元を優先する)
+func (r *mergeRunner) copyFile(srcFile, relFile string, srcInfo os.FileInfo, job *JobStatus) {
+	dstFile := filepath.Join(r.dst, relFile)
+	if r.claims == nil {
+		copyTarget(srcFile, dstFile, relFile, srcInfo, job)
+		return
+	}
+
+	path := filepath.Join(r.prefix, relFile)
+	claim := r.claims.get(path)
+	claim.mu.Lock()
+	defer claim.mu.Unlock()
+	switch {
+	case claim.owner < 0 || claim.owner == r.index:
+		claim.owner = r.index
+		claim.replace = false
+		claim.result = copyTarget(srcFile, dstFile, relFile, srcInfo, job)
+
+	case claim.owner < r.index:
+		// 先に指定したコピー元がコピー済み
+		slog.Warn("Collision", "file", path, "source", r.src)
+		claim.skipped = append(claim.skipped, r.index)
+		job.AddSkipFile(SkipCollision)
+
+	case claim.result == copyNone:
+		// 後に指定したコピー元が書き込んでいない場合は、通常どおり差分、上書きの設定をチェックする
+		slog.Warn("Collision", "file", path, "source", r.claims.names[claim.owner])
+		claim.skipped = append(claim.skipped, claim.owner)
+		claim.owner = r.index
+		claim.replace = false
+		claim.result = copyTarget(srcFile, dstFile, relFile, srcInfo, job)
+
+	default:
+		// 後に指定したコピー元が書き込んだファイルは、差分、上書きの設定によらず上書きする
+		// (実行前のコピー先ファイルは後に指定したコピー元の書き込み時に退避済みのため退避しない)
+		slog.Warn("Collision", "file", path, "source", r.claims.names[claim.owner])
+		r.claims.jobs[claim.owner].moveToCollision(claim.result)
+		claim.skipped = append(claim.skipped, claim.owner)
+		claim.owner = r.index
+		claim.replace = true
+		claim.result = replaceTarget(srcFile, dstFile, relFile, srcInfo, job)
+	}
+}
+
+// 後に指定したコピー元が書き込んだファイルを置き換える
+// (ハードリンクの場合にリンク先を書き換えないよう削除してから書き込む)
+func replaceTarget(srcFile, dstFile, relFile string, srcInfo os.FileInfo, job *JobStatus) copyResult {
+	err := os.Remove(dstFile)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Error("File Copy", "file", srcFile, "ERROR", err)
+		job.AddErrorFile(relFile)
+		return copyNone
+	}
+	return writeTarget(srcFile, dstFile, relFile, srcInfo, job)
+}
+
+// 最初のコピーと同じく差分、上書き、退避、リンクの設定に従ってリトライする
+// (先に指定したコピー元がコピーしたファイルはリトライしない、エラーは集計結果に記録済み)
+func (r *mergeRunner) Retry(srcDir, targetFile string) error {
+	srcFile := filepath.Join(srcDir, targetFile)
+	dstFile := filepath.Join(r.dst, targetFile)
+	srcInfo, err := os.Stat(srcFile)
+	if err != nil {
+		return err
+	}
+	if r.claims == nil {
+		copyTarget(srcFile, dstFile, targetFile, srcInfo, r.job)
+		return nil
+	}
+
+	claim := r.claims.get(filepath.Join(r.prefix, targetFile))
+	claim.mu.Lock()
+	defer claim.mu.Unlock()
+	if claim.owner != r.index {
+		return nil
+	}
+	if claim.replace {
+		claim.result = replaceTarget(srcFile, dstFile, targetFile, srcInfo, r.job)
+	} else {
+		claim.result = copyTarget(srcFile, dstFile, targetFile, srcInfo, r.job)
+	}
+	return nil
+}
+
+// コピー先のファイルをどのコピー元がコピーしたか記録する
+type mergeClaims struct {
+	mu    sync.Mutex
+	names []string
+	jobs  []*JobStatus // コピー元ごとの集計
+	files map[string]*mergeClaim
+}
+
+type mergeClaim struct {
+	mu      sync.Mutex
+	owner   int        // コピーしたコピー元(未コピーは-1)
+	result  copyResult // コピーしたコピー元の書き込み結果
+	replace bool       // 後に指定したコピー元が書き込んだファイルを置き換えた
+	skipped []int      // コピーしなかったコピー元
+}
+
+func (c *mergeClaims) get(path string) *mergeClaim {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	claim, ok := c.files[path]
+	if !ok {
+		claim = &mergeClaim{owner: -1}
+		c.files[path] = claim
+	}
+	return claim
+}
+
+func (c *mergeClaims) owner(path string) int {
+	claim := c.get(path)
+	claim.mu.Lock()
+	defer claim.mu.Unlock()
+	return claim.owner
+}
+
+// 同じコピー先になったファイルの一覧(コピー先のパス、コピー元の指定順)
+func (c *mergeClaims) list() []MergeCollision {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	collisions := []MergeCollision{}
+	for path, claim := range c.files {
+		sort.Ints(claim.skipped)
+		for _, i := range claim.skipped {
+			collisions = append(collisions, MergeCollision{Path: path, Kept: c.names[claim.owner], Skipped: c.names[i]})
+		}
+	}
+	sort.SliceStable(collisions, func(i, k int) bool {
+		return collisions[i].Path < collisions[k].Path
+	})
+	return collisions
+}
+
+// 「コピー元=サブディレクトリ」の形式の引数を分割する
+// (最後の '=' で分割するため、コピー元のパスには '=' を含められるがサブディレクトリには含められない)
+func ParseMergeSource(arg string) MergeSource {
+	i := strings.LastIndex(arg, "=")
+	if i < 0 {
+		return MergeSource{Dir: arg}
+	}
+	return MergeSource{Dir: arg[:i], Prefix: arg[i+1:]}
+}
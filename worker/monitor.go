@@ -0,0 +1,176 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/coco-papiyon/mechacopy/watch"
+)
+
+// 変更を監視できない場合に再走査しない設定でも使用する再走査の間隔
+const DefaultRescan = 60 * time.Minute
+
+// コピー元を監視し、変更があった場合に再実行する
+type Monitor struct {
+	Debounce time.Duration // 最後の変更から再実行までの待機時間
+	Rescan   time.Duration // 全体を再走査する間隔(0は再走査しない)
+}
+
+// 監視を開始する(ctx が終了するまで実行する)
+func (m *Monitor) Run(ctx context.Context, srcDir string, runner Runner, config *Config) error {
+	filter, err := newFilter(srcDir, config)
+	if err != nil {
+		slog.Error("ファイルパターン", "ERROR", err)
+		return err
+	}
+
+	// 初回の実行中の変更も検知できるよう、先に監視を開始する
+	var events <-chan string
+	var errs <-chan error
+	w, err := watch.New(srcDir, filter.IsExcludeDir)
+	interval := m.rescanInterval(err)
+	switch {
+	case errors.Is(err, watch.ErrNotSupported):
+		slog.Warn("変更の監視", "ERROR", err, "再走査の間隔", interval)
+	case err != nil:
+		slog.Error("変更の監視", "ERROR", err, "basePath", srcDir)
+		return err
+	default:
+		defer w.Close()
+		events = w.Events
+		errs = w.Errors
+	}
+
+	// 初回は全体をコピー
+	err = RunMecha(srcDir, runner, config)
+	if err != nil {
+		return err
+	}
+
+	var rescan <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		rescan = ticker.C
+	}
+	debounce := time.NewTimer(m.Debounce)
+	debounce.Stop()
+
+	// 全体を実行する場合は待機中の変更を破棄する
+	pending := map[string]bool{}
+	clearPending := func() {
+		pending = map[string]bool{}
+		if !debounce.Stop() {
+			select {
+			case <-debounce.C:
+			default:
+			}
+		}
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case dir := <-events:
+			// 変更が続く間は待機する
+			pending[dir] = true
+			debounce.Reset(m.Debounce)
+
+		case err := <-errs:
+			if !errors.Is(err, watch.ErrOverflow) {
+				slog.Error("変更の監視", "ERROR", err, "basePath", srcDir)
+				return err
+			}
+			slog.Warn("変更の監視", "ERROR", err)
+			clearPending()
+			err = RunMecha(srcDir, runner, config)
+			if err != nil {
+				return err
+			}
+
+		case <-debounce.C:
+			dirs := make([]string, 0, len(pending))
+			for dir := range pending {
+				dirs = append(dirs, dir)
+			}
+			sort.Strings(dirs)
+			pending = map[string]bool{}
+			if len(dirs) == 0 {
+				continue
+			}
+			slog.Info("Changed Directories", "Count", len(dirs))
+			err = RunDirs(srcDir, dirs, runner, config)
+			if err != nil {
+				return err
+			}
+
+		case <-rescan:
+			slog.Info("Rescan", "basePath", srcDir)
+			clearPending()
+			err = RunMecha(srcDir, runner, config)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// 再走査の間隔(変更を監視できない場合は、再走査しない設定でも既定の間隔で再走査する)
+func (m *Monitor) rescanInterval(watchErr error) time.Duration {
+	if m.Rescan <= 0 && errors.Is(watchErr, watch.ErrNotSupported) {
+		return DefaultRescan
+	}
+	return m.Rescan
+}
+
+// 指定したディレクトリのみを処理する(サブディレクトリは走査しない)
+func RunDirs(srcDir string, dirs []string, runner Runner, config *Config) error {
+	start := time.Now()
+
+	// コピー対象ファイルの判定
+	filter, err := newFilter(srcDir, config)
+	if err != nil {
+		slog.Error("ファイルパターン", "ERROR", err)
+		return err
+	}
+
+	// 同時実行用の制御
+	job := newJob(config, filter)
+
+	// 指定した数スレッド(goroutine)を起動
+	for i := 0; i < config.CopyThread; i++ {
+		go runWorker(srcDir, runner, job, job.ch)
+	}
+
+	// 対象のディレクトリを送信(削除されたディレクトリ、除外ディレクトリは処理しない)
+	loaded := map[string]bool{}
+	for _, dir := range dirs {
+		info, err := os.Stat(filepath.Join(srcDir, dir))
+		if err != nil || !info.IsDir() || isExcludedDir(filter, dir, loaded) {
+			continue
+		}
+		job.AddTotal()
+		job.wg.Add(1)
+		job.ch <- dir
+	}
+	close(job.ch)
+
+	// 処理待ち
+	job.wg.Wait()
+
+	// エラーリトライ
+	if config.Retry {
+		runRetry(srcDir, runner, job)
+	}
+
+	if !config.Quiet {
+		printSummary(start, job)
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package worker
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/coco-papiyon/mechacopy/filecopy"
+)
+
+// 複数のコピー先にコピーする(コピー元のファイルは一度だけ読み込む)
+type MultiCopyRunner struct {
+	Destinations []string
+
+	mu     sync.Mutex
+	job    *JobStatus
+	failed map[string][]int // コピーできなかったファイル -> コピー先
+}
+
+func (r *MultiCopyRunner) Run(baseDir, srcDir string, job *JobStatus) error {
+	r.init(job)
+
+	// ディレクトリ内のファイル一覧を取得
+	entries, err := os.ReadDir(filepath.Join(baseDir, srcDir))
+	if err != nil {
+		job.AddErrorDirs(srcDir)
+		return err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			r.copyFile(filepath.Join(baseDir, srcDir, entry.Name()), filepath.Join(srcDir, entry.Name()), entry, job)
+		}
+	}
+	return nil
+}
+
+// 指定したファイルをコピーする(ファイル一覧を指定した場合)
+func (r *MultiCopyRunner) RunFile(baseDir, relFile string, entry os.DirEntry, job *JobStatus) {
+	r.init(job)
+	r.copyFile(filepath.Join(baseDir, relFile), relFile, entry, job)
+}
+
+// 実行ごとの状態を初期化する(監視で繰り返し実行する場合も実行ごとに集計する)
+func (r *MultiCopyRunner) init(job *JobStatus) {
+	job.initDests(r.Destinations)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.job != job {
+		r.job = job
+		r.failed = map[string][]int{}
+	}
+}
+
+// ファイルを差分があるコピー先にコピーする(スキップ、エラーはコピー先ごとに記録する)
+func (r *MultiCopyRunner) copyFile(srcFile, relFile string, entry os.DirEntry, job *JobStatus) {
+	// コピー対象のファイルではない場合はスキップする
+	if _, ok := selectFile(srcFile, relFile, entry, job); !ok {
+		return
+	}
+
+	// コピー先ごとに差分、上書きの設定をチェックする
+	targets := []int{}
+	var skipReason SkipReason
+	backupFailed := false
+	for i, dst := range r.Destinations {
+		dstFile := filepath.Join(dst, relFile)
+		diff := filecopy.CompareFile(srcFile, dstFile)
+		if reason, skip := skipOverwrite(diff, job.config); skip {
+			slog.Debug("Skip File", "file", dstFile, "reason", reason)
+			job.AddDestSkip(i)
+			skipReason = reason
+			continue
+		}
+
+		// 上書きする場合はコピー先ファイルを退避する
+		if job.backup != nil && diff != filecopy.DiffMissing {
+			err := job.backup.Save(dstFile, relFile)
+			if err != nil {
+				// リトライでは退避せずに上書きしてしまうため、リトライしない
+				slog.Error("Backup File", "file", dstFile, "ERROR", err)
+				job.AddDestError(i, relFile)
+				backupFailed = true
+				continue
+			}
+		}
+		targets = append(targets, i)
+	}
+	if len(targets) == 0 {
+		if backupFailed {
+			job.AddBackupErrorFile(relFile)
+		} else {
+			job.AddSkipFile(skipReason)
+		}
+		return
+	}
+
+	// ファイルコピー
+	failed := r.copyTo(srcFile, relFile, targets, job.AddDestSuccess, job.AddDestError)
+	switch {
+	case len(failed) > 0:
+		r.setFailed(relFile, failed)
+		job.AddErrorFile(relFile)
+	case backupFailed:
+		job.AddBackupErrorFile(relFile)
+	default:
+		job.AddSuccessFile()
+	}
+}
+
+// 指定したコピー先にコピーする(コピーできなかったコピー先を返す)
+func (r *MultiCopyRunner) copyTo(srcFile, relFile string, targets []int, success func(int), failure func(int, string)) []int {
+	if len(targets) == 0 {
+		return nil
+	}
+	dstFiles := make([]string, len(targets))
+	for k, i := range targets {
+		dstFiles[k] = filepath.Join(r.Destinations[i], relFile)
+	}
+	errs, err := filecopy.CopyFileMulti(srcFile, dstFiles)
+
+	failed := []int{}
+	for k, i := range targets {
+		if dstErr := errors.Join(err, errs[k]); dstErr != nil {
+			slog.Error("File Copy", "file", srcFile, "destination", r.Destinations[i], "ERROR", dstErr)
+			failure(i, relFile)
+			failed = append(failed, i)
+			continue
+		}
+		success(i)
+	}
+	return failed
+}
+
+func (r *MultiCopyRunner) setFailed(relFile string, failed []int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.failed[relFile] = failed
+}
+
+// コピーできなかったコピー先のみ再コピーする
+func (r *MultiCopyRunner) Retry(srcDir, targetFile string) error {
+	r.mu.Lock()
+	targets := r.failed[targetFile]
+	job := r.job
+	r.mu.Unlock()
+	if job == nil {
+		return errors.New("コピーが実行されていません")
+	}
+
+	failed := r.copyTo(filepath.Join(srcDir, targetFile), targetFile, targets,
+		func(i int) { job.RetryDestSuccess(i, targetFile) },
+		func(int, string) {},
+	)
+	r.setFailed(targetFile, failed)
+	if len(failed) > 0 {
+		return errors.New("コピーできないコピー先があります")
+	}
+	return nil
+}
@@ -0,0 +1,450 @@
+package worker
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coco-papiyon/mechacopy/filecopy"
+)
+
+// 計画の操作
+type PlanOp string
+
+const (
+	PlanMkdir     PlanOp = "mkdir"     // ディレクトリを作成する
+	PlanCopy      PlanOp = "copy"      // コピー先に存在しないファイルをコピーする
+	PlanOverwrite PlanOp = "overwrite" // コピー先のファイルを上書きする
+	PlanDelete    PlanOp = "delete"    // コピー先のみに存在するファイル、ディレクトリを削除する
+)
+
+// 実行する順序
+var planOps = []PlanOp{PlanMkdir, PlanCopy, PlanOverwrite, PlanDelete}
+
+// 計画したファイル操作
+type PlanItem struct {
+	Op      PlanOp     `json:"op"`
+	Path    string     `json:"path"`            // コピー元、コピー先からの相対パス
+	Reason  string     `json:"reason"`          // 操作する理由
+	Dir     bool       `json:"dir,omitempty"`   // ディレクトリの削除
+	Size    int64      `json:"size,omitempty"`  // 計画時のコピー元のサイズ
+	ModTime *time.Time `json:"mtime,omitempty"` // 計画時のコピー元の更新日時
+}
+
+// ファイル操作の計画
+type Plan struct {
+	Source      string     `json:"source"`
+	Destination string     `json:"destination"`
+	Created     time.Time  `json:"created"`
+	Items       []PlanItem `json:"items"`
+	Errors      []string   `json:"errors"` // 計画時に読み込めなかったファイル、ディレクトリ
+}
+
+// 計画後にコピー元が変更された
+var errSourceChanged = errors.New("計画後にコピー元が変更されています")
+
+// 計画後にコピー先が作成された
+var errDestinationChanged = errors.New("計画後にコピー先が作成されています")
+
+// コピーするファイルを計画する(コピーはしない)
+type PlanRunner struct {
+	Destination string
+	Delete      bool // コピー先のみに存在するファイル、ディレクトリを削除する
+
+	mu    sync.Mutex
+	items []PlanItem
+	errs  []string
+}
+
+// ディレクトリ内のファイルの操作を計画する(サブディレクトリは無視)
+func (r *PlanRunner) Run(baseDir, srcDir string, job *JobStatus) error {
+	srcPath := filepath.Join(baseDir, srcDir)
+	dstPath := filepath.Join(r.Destination, srcDir)
+
+	// ディレクトリ内のファイル一覧を取得
+	srcEntries, err := os.ReadDir(srcPath)
+	if err != nil {
+		job.AddErrorDirs(srcDir)
+		return err
+	}
+	dstEntries, err := os.ReadDir(dstPath)
+	switch {
+	case os.IsNotExist(err):
+		r.add(PlanItem{Op: PlanMkdir, Path: srcDir, Reason: string(DiffOnlySource)})
+	case err != nil:
+		job.AddErrorDirs(srcDir)
+		return err
+	}
+
+	srcNames := map[string]bool{}
+	for _, entry := range srcEntries {
+		srcNames[entry.Name()] = entry.IsDir()
+		if entry.IsDir() {
+			continue
+		}
+		relFile := filepath.Join(srcDir, entry.Name())
+		srcInfo, ok := selectFile(filepath.Join(srcPath, entry.Name()), relFile, entry, job)
+		if !ok {
+			continue
+		}
+		if srcInfo == nil {
+			job.AddErrorFile(relFile)
+			continue
+		}
+
+		// 差分がない場合、上書きしない場合はコピーしない
+		diff := filecopy.CompareFile(filepath.Join(srcPath, entry.Name()), filepath.Join(dstPath, entry.Name()))
+		if reason, skip := skipOverwrite(diff, job.config); skip {
+			job.AddSkipFile(reason)
+			continue
+		}
+		item := PlanItem{
+			Op:      PlanOverwrite,
+			Path:    relFile,
+			Reason:  diffReason(diff),
+			Size:    srcInfo.Size(),
+			ModTime: timePtr(srcInfo.ModTime()),
+		}
+		if diff == filecopy.DiffMissing {
+			item.Op = PlanCopy
+		}
+		r.add(item)
+		job.AddSuccessFile()
+	}
+
+	// コピー先のみに存在するファイル、ディレクトリを削除する
+	if !r.Delete {
+		return nil
+	}
+	for _, entry := range dstEntries {
+		isDir, exist := srcNames[entry.Name()]
+		relFile := filepath.Join(srcDir, entry.Name())
+		switch {
+		case entry.IsDir() && !exist:
+			if !job.filter.IsExcludeDir(relFile) {
+				r.addDelete(relFile, job)
+			}
+		case !entry.IsDir() && (!exist || isDir):
+			if job.filter.IsCopyFile(relFile) {
+				r.add(PlanItem{Op: PlanDelete, Path: relFile, Reason: string(DiffOnlyDestination)})
+			}
+		}
+	}
+	return nil
+}
+
+// コピー先のみに存在するディレクトリを削除する
+func (r *PlanRunner) addDelete(relDir string, job *JobStatus) {
+	root := filepath.Join(r.Destination, relDir)
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(r.Destination, path)
+		if d.IsDir() {
+			if path != root && job.filter.IsExcludeDir(rel) {
+				return filepath.SkipDir
+			}
+			r.add(PlanItem{Op: PlanDelete, Path: rel, Reason: string(DiffOnlyDestination), Dir: true})
+			return nil
+		}
+		if job.filter.IsCopyFile(rel) {
+			r.add(PlanItem{Op: PlanDelete, Path: rel, Reason: string(DiffOnlyDestination)})
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Directory Plan", "directory", root, "ERROR", err)
+		job.AddErrorDirs(relDir)
+	}
+}
+
+func (r *PlanRunner) add(item PlanItem) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.items = append(r.items, item)
+}
+
+// 計画できなかったファイル、ディレクトリを記録する
+func (r *PlanRunner) Finish(baseDir string, dirs []string, job *JobStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	job.mu.Lock()
+	defer job.mu.Unlock()
+	r.errs = append(r.errs, job.errorDirs...)
+	r.errs = append(r.errs, job.errorFiles...)
+	return nil
+}
+
+// 計画はリトライしない
+func (r *PlanRunner) Retry(srcDir, targetFile string) error {
+	return nil
+}
+
+// 計画を取得する(操作の順序、パス順)
+func (r *PlanRunner) Plan(srcDir string) *Plan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	plan := &Plan{
+		Source:      srcDir,
+		Destination: r.Destination,
+		Created:     time.Now(),
+		Items:       append([]PlanItem{}, r.items...),
+		Errors:      append([]string{}, r.errs...),
+	}
+	order := map[PlanOp]int{}
+	for i, op := range planOps {
+		order[op] = i
+	}
+	sort.Slice(plan.Items, func(i, j int) bool {
+		a, b := plan.Items[i], plan.Items[j]
+		if a.Op != b.Op {
+			return order[a.Op] < order[b.Op]
+		}
+		return a.Path < b.Path
+	})
+	sort.Strings(plan.Errors)
+	return plan
+}
+
+// 比較結果を操作の理由にする
+func diffReason(diff filecopy.FileDiff) string {
+	switch diff {
+	case filecopy.DiffMissing:
+		return string(DiffOnlySource)
+	case filecopy.DiffNewer:
+		return "newer"
+	case filecopy.DiffOlder:
+		return "older"
+	case filecopy.DiffChanged:
+		return "changed"
+	}
+	return "unknown"
+}
+
+// 計画をJSON形式で出力する
+func WritePlan(w io.Writer, plan *Plan) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
+
+// JSON形式の計画を読み込む
+func ReadPlan(r io.Reader) (*Plan, error) {
+	plan := &Plan{}
+	err := json.NewDecoder(r).Decode(plan)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Source == "" || plan.Destination == "" {
+		return nil, fmt.Errorf("コピー元、コピー先がありません")
+	}
+	for _, item := range plan.Items {
+		if !isPlanOp(item.Op) {
+			return nil, fmt.Errorf("不明な操作です: %s %s", item.Op, item.Path)
+		}
+		if filepath.IsAbs(item.Path) {
+			return nil, fmt.Errorf("相対パスではありません: %s", item.Path)
+		}
+		if _, err := relPath(plan.Source, item.Path); err != nil {
+			return nil, err
+		}
+	}
+	return plan, nil
+}
+
+func isPlanOp(op PlanOp) bool {
+	for _, o := range planOps {
+		if o == op {
+			return true
+		}
+	}
+	return false
+}
+
+// 計画を実行する
+type planApplier struct {
+	plan  *Plan
+	items map[string]PlanItem // ファイル操作(リトライ用)
+	job   *JobStatus
+}
+
+// 計画どおりにファイルを操作する(計画後にコピー元が変更されたファイルは操作しない)
+func ApplyPlan(plan *Plan, config *Config) error {
+	start := time.Now()
+
+	// 同時実行用の制御
+	job := newJob(config, nil)
+	a := &planApplier{plan: plan, items: map[string]PlanItem{}, job: job}
+
+	// ディレクトリを作成
+	dirs := []PlanItem{}
+	for _, item := range plan.Items {
+		switch {
+		case item.Op == PlanMkdir:
+			err := os.MkdirAll(filepath.Join(plan.Destination, item.Path), os.ModePerm)
+			if err != nil {
+				slog.Error("Make Directory", "directory", item.Path, "ERROR", err)
+				job.AddErrorDirs(item.Path)
+			}
+		case item.Dir:
+			dirs = append(dirs, item)
+		default:
+			a.items[item.Path] = item
+		}
+	}
+
+	// 指定した数スレッド(goroutine)でファイルを操作
+	ch := make(chan PlanItem)
+	for i := 0; i < config.CopyThread; i++ {
+		go func() {
+			for item := range ch {
+				a.run(item)
+				job.AddSuccess()
+				slog.Info(fmt.Sprintf("%s %s %s", job.GetStatus(), item.Op, item.Path))
+				job.wg.Done()
+			}
+		}()
+	}
+	for _, item := range plan.Items {
+		if item.Op == PlanMkdir || item.Dir {
+			continue
+		}
+		job.AddTotal()
+		job.wg.Add(1)
+		ch <- item
+	}
+	close(ch)
+	job.wg.Wait()
+
+	// エラーリトライ
+	if config.Retry {
+		runRetry(plan.Source, a, job)
+	}
+
+	// ディレクトリを深い階層から順に削除(空でない場合は削除しない)
+	sort.Slice(dirs, func(i, j int) bool { return dirs[i].Path > dirs[j].Path })
+	for _, item := range dirs {
+		err := os.Remove(filepath.Join(plan.Destination, item.Path))
+		if err != nil && !os.IsNotExist(err) {
+			slog.Warn("Delete Directory", "directory", item.Path, "ERROR", err)
+		}
+	}
+
+	if !config.Quiet {
+		printSummary(start, job)
+	}
+
+	// 操作しなかったファイル、エラーがある場合は計画どおりに実行できていない
+	if len(job.refusedFiles) > 0 || len(job.errorFiles) > 0 || len(job.errorDirs) > 0 {
+		return fmt.Errorf("計画どおりに実行できませんでした (操作しないファイル %d, エラー %d, ディレクトリのエラー %d)",
+			len(job.refusedFiles), len(job.errorFiles), len(job.errorDirs))
+	}
+	return nil
+}
+
+// 計画後に変更されたため操作しないエラーか
+func isPlanChanged(err error) bool {
+	return errors.Is(err, errSourceChanged) || errors.Is(err, errDestinationChanged)
+}
+
+// ファイルを操作して結果を記録する
+func (a *planApplier) run(item PlanItem) {
+	err := a.apply(item, false)
+	switch {
+	case isPlanChanged(err):
+		slog.Warn("Refuse File", "file", item.Path, "ERROR", err)
+		a.job.AddSkipFile(SkipModified)
+		a.job.AddRefusedFile(item.Path)
+	case err != nil:
+		slog.Error("Apply File", "op", item.Op, "file", item.Path, "ERROR", err)
+		a.job.AddErrorFile(item.Path)
+	default:
+		a.job.AddSuccessFile()
+	}
+}
+
+// ファイルを操作する(リトライの場合は前回のコピーで作成されたコピー先は確認しない)
+func (a *planApplier) apply(item PlanItem, retry bool) error {
+	src := filepath.Join(a.plan.Source, item.Path)
+	dst := filepath.Join(a.plan.Destination, item.Path)
+	info, err := os.Stat(src)
+
+	// 削除: 計画後にコピー元に作成された場合は削除しない
+	if item.Op == PlanDelete {
+		if err == nil && !info.IsDir() {
+			return errSourceChanged
+		}
+		err = os.Remove(dst)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+
+	// コピー、上書き: 計画時とサイズ、更新日時が異なる場合はコピーしない
+	if os.IsNotExist(err) {
+		return errSourceChanged
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() != item.Size || item.ModTime == nil || !info.ModTime().Equal(*item.ModTime) {
+		return errSourceChanged
+	}
+
+	// コピー: 計画後にコピー先が作成された場合は上書きしない
+	if item.Op == PlanCopy && !retry {
+		if _, err := os.Lstat(dst); !os.IsNotExist(err) {
+			return errDestinationChanged
+		}
+	}
+
+	// 上書きする場合はコピー先ファイルを退避する
+	if a.job.backup != nil && item.Op == PlanOverwrite {
+		err = a.job.backup.Save(dst, item.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return filecopy.CopyFile(src, dst)
+}
+
+// エラーとなったファイルの操作をリトライする
+func (a *planApplier) Retry(srcDir, targetFile string) error {
+	item, ok := a.items[targetFile]
+	if !ok {
+		return fmt.Errorf("計画にないファイルです: %s", targetFile)
+	}
+	err := a.apply(item, true)
+	if isPlanChanged(err) {
+		a.job.AddSkipFile(SkipModified)
+		a.job.AddRefusedFile(item.Path)
+		return nil
+	}
+	return err
+}
+
+// 操作の一覧をテキスト形式で出力する
+func (plan *Plan) WriteText(w io.Writer) error {
+	for _, item := range plan.Items {
+		path := item.Path
+		if item.Dir || item.Op == PlanMkdir {
+			path = strings.TrimSuffix(path, string(filepath.Separator)) + string(filepath.Separator)
+		}
+		_, err := fmt.Fprintf(w, "%-10s %-16s %s\n", item.Op, item.Reason, path)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
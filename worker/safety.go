@@ -0,0 +1,100 @@
+package worker
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// 既定で削除を禁止するディレクトリ(ホームディレクトリ)
+func DefaultProtectedPaths() []string {
+	paths := []string{}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths, home)
+	}
+	return paths
+}
+
+// 削除してよいディレクトリかチェックする
+// (ファイルシステムのルート、保護対象のディレクトリとその上位ディレクトリは削除しない)
+func CheckDeleteTarget(target string, protected []string) error {
+	abs, err := absPath(target)
+	if err != nil {
+		return err
+	}
+	if filepath.Dir(abs) == abs {
+		return fmt.Errorf("ファイルシステムのルートは削除できません: %s", abs)
+	}
+
+	for _, path := range protected {
+		p, err := absPath(path)
+		if err != nil {
+			continue
+		}
+		if p == abs || isParentDir(abs, p) {
+			return fmt.Errorf("保護対象のディレクトリを含むため削除できません: %s (保護対象 %s)", abs, p)
+		}
+	}
+	return nil
+}
+
+// 絶対パスに変換する(シンボリックリンクは解決する)
+func absPath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	if real, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = real
+	}
+	return filepath.Clean(abs), nil
+}
+
+// parentがchildの上位ディレクトリかチェックする
+func isParentDir(parent, child string) bool {
+	rel, err := filepath.Rel(parent, child)
+	if err != nil {
+		return false
+	}
+	return rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// 対象ファイル(パターン、サイズ、更新日時)の数と合計サイズを数える
+func CountTargetFiles(srcDir string, config *Config) (int64, int64, error) {
+	filter, err := newFilter(srcDir, config)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	dirCh := make(chan string, config.ScanThread)
+	walker := newWalker(config, filter)
+	var walkErr error
+	go func() {
+		walkErr = walker.Walk(srcDir, dirCh)
+		close(dirCh)
+	}()
+
+	var files, bytes int64
+	for dir := range dirCh {
+		entries, err := os.ReadDir(filepath.Join(srcDir, dir))
+		if err != nil {
+			continue
+		}
+		for _, entry := range entries {
+			if entry.IsDir() {
+				continue
+			}
+			relFile := filepath.Join(dir, entry.Name())
+			info, _, ok := checkFile(filter, filepath.Join(srcDir, relFile), relFile, entry)
+			if !ok {
+				continue
+			}
+			files++
+			if info != nil {
+				bytes += info.Size()
+			}
+		}
+	}
+	return files, bytes, walkErr
+}
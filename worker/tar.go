@@ -0,0 +1,196 @@
+package worker
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// コピー先をtarアーカイブとして書き込む
+// (エントリの順序を一定にするため、ファイルは全ディレクトリの走査後にパス順に書き込む)
+type TarRunner struct {
+	Writer io.Writer // 書き込み先(ファイル、標準出力)
+	Gzip   bool      // gzipで圧縮する
+
+	mu    sync.Mutex
+	files []string
+	err   error
+}
+
+// ディレクトリ内のコピー対象ファイルを記録する(サブディレクトリは無視)
+func (r *TarRunner) Run(baseDir, srcDir string, job *JobStatus) error {
+	// ディレクトリ内のファイル一覧を取得
+	entries, err := os.ReadDir(filepath.Join(baseDir, srcDir))
+	if err != nil {
+		job.AddErrorDirs(srcDir)
+		return err
+	}
+
+	files := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		relFile := filepath.Join(srcDir, entry.Name())
+		if _, ok := selectFile(filepath.Join(baseDir, relFile), relFile, entry, job); ok {
+			files = append(files, relFile)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.files = append(r.files, files...)
+	return nil
+}
+
+// 記録したディレクトリ、ファイルをパス順にアーカイブに書き込む
+func (r *TarRunner) Finish(baseDir string, dirs []string, job *JobStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	type tarItem struct {
+		name string
+		path string
+		dir  bool
+	}
+	items := []tarItem{}
+	for _, dir := range dirs {
+		if dir != "." {
+			items = append(items, tarItem{name: filepath.ToSlash(dir) + "/", path: dir, dir: true})
+		}
+	}
+	for _, file := range r.files {
+		items = append(items, tarItem{name: filepath.ToSlash(file), path: file})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].name < items[j].name
+	})
+
+	w := r.Writer
+	var zw *gzip.Writer
+	if r.Gzip {
+		zw = gzip.NewWriter(w)
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	var writeErr *tarWriteError
+	for _, item := range items {
+		var err error
+		if item.dir {
+			err = writeTarDir(tw, filepath.Join(baseDir, item.path), item.name)
+		} else {
+			err = writeTarFile(tw, filepath.Join(baseDir, item.path), item.name)
+		}
+		switch {
+		case err == nil:
+			if !item.dir {
+				job.AddSuccessFile()
+			}
+		case errors.As(err, &writeErr):
+			// 書き込みに失敗した場合はアーカイブが壊れているため中断する
+			r.err = err
+			return err
+		case item.dir:
+			slog.Error("Tar Directory", "dir", item.path, "ERROR", err)
+			job.AddErrorDirs(item.path)
+		default:
+			slog.Error("Tar File", "file", item.path, "ERROR", err)
+			job.AddErrorFile(item.path)
+		}
+	}
+
+	// アーカイブの終端を書き込む
+	r.err = tw.Close()
+	if r.err == nil && zw != nil {
+		r.err = zw.Close()
+	}
+	return r.err
+}
+
+// アーカイブはリトライしない(全ディレクトリの走査後に書き込むため)
+func (r *TarRunner) Retry(srcDir, targetFile string) error {
+	return nil
+}
+
+// アーカイブの書き込みエラーを取得する
+func (r *TarRunner) Err() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.err
+}
+
+// アーカイブへの書き込みエラー(コピー元の読み込みエラーと区別する)
+type tarWriteError struct {
+	err error
+}
+
+func (e *tarWriteError) Error() string {
+	return e.err.Error()
+}
+
+func (e *tarWriteError) Unwrap() error {
+	return e.err
+}
+
+// ディレクトリのヘッダーを書き込む
+func writeTarDir(tw *tar.Writer, path, name string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	hdr, err := tarHeader(info, name)
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return &tarWriteError{err}
+	}
+	return nil
+}
+
+// ファイルのヘッダーと内容を書き込む(シンボリックリンクはリンク先の内容を書き込む)
+func writeTarFile(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tarHeader(info, name)
+	if err != nil {
+		return err
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return &tarWriteError{err}
+	}
+
+	// ヘッダーを書き込んだ後はサイズ分を書き込まないとアーカイブが壊れる
+	if _, err := io.CopyN(tw, f, hdr.Size); err != nil {
+		return &tarWriteError{err}
+	}
+	return nil
+}
+
+// ファイル情報からヘッダーを作成する(更新日時、パーミッションを保持する)
+func tarHeader(info os.FileInfo, name string) (*tar.Header, error) {
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return nil, err
+	}
+	hdr.Name = name
+	hdr.Format = tar.FormatPAX
+	// 実行ごとに変わる値は書き込まない
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+	return hdr, nil
+}
@@ -3,23 +3,54 @@ package worker
 import (
 	"fmt"
 	"log/slog"
+	"os"
 	"sync"
 	"time"
 
 	"github.com/coco-papiyon/mechacopy/directory"
+	"github.com/coco-papiyon/mechacopy/filecopy"
 )
 
 type Config struct {
-	CopyThread  int
-	RetryCount  int
-	SleepTime   int
-	TargetFiles []string
-	Retry       bool
+	CopyThread   int
+	ScanThread   int
+	MaxDepth     int
+	OneFS        bool
+	RetryCount   int
+	SleepTime    int
+	TargetFiles  []string
+	ExcludeFiles []string
+	ExcludeDirs  []string
+	FilterFrom   []string
+	IgnoreFile   string
+	MinSize      int64
+	MaxSize      int64
+	MinAge       time.Duration
+	MaxAge       time.Duration
+	Retry        bool
+	Quiet        bool // 集計結果を出力しない
+
+	// 上書きの設定
+	ExcludeOlder   bool // コピー元の方が古いファイルを上書きしない
+	ExcludeNewer   bool // コピー元の方が新しいファイルを上書きしない
+	ExcludeChanged bool // 更新日時が同じでサイズが異なるファイルを上書きしない
+	NoOverwrite    bool // コピー先に存在するファイルは上書きしない
+
+	// 上書きするファイルのバックアップ
+	Backup          bool   // 上書き前にコピー先ファイルを退避する
+	BackupDir       string // 退避先ディレクトリ(空の場合はコピー先と同じディレクトリ)
+	BackupTimestamp bool   // 日時を付けて世代管理する
+	BackupKeep      int    // ファイルごとに残す世代数(0は無制限)
+
+	// スナップショット
+	Snapshot bool   // コピー先に日時のディレクトリを作成してコピーする
+	LinkDest string // 差分がないファイルはこのディレクトリのファイルへのハードリンクにする
 }
 
 func InitConfig() *Config {
 	return &Config{
 		CopyThread:  10,
+		ScanThread:  4,
 		RetryCount:  10,
 		SleepTime:   10,
 		TargetFiles: []string{"*"},
@@ -32,42 +63,101 @@ type Runner interface {
 	Retry(string, string) error
 }
 
+// エラーとなったファイルをリトライする
+type retrier interface {
+	Retry(string, string) error
+}
+
+// 全ディレクトリの処理後に実行する処理を持つRunner
+// (走査したディレクトリの一覧を受け取る)
+type Finisher interface {
+	Finish(string, []string, *JobStatus) error
+}
+
 // ディレクトリ内のファイルをすべてコピーする(同時実行制御)
 func RunMecha(srcDir string, runner Runner, config *Config) error {
 	start := time.Now()
 
-	// 指定ディレクトリ内のディレクトリを取得
-	srcDirs, err := directory.GetDirs(srcDir)
+	// コピー対象ファイルの判定
+	filter, err := newFilter(srcDir, config)
 	if err != nil {
-		slog.Error("ディレクトリ取得", "ERROR", err, "basePath", srcDir)
+		slog.Error("ファイルパターン", "ERROR", err)
 		return err
 	}
 
 	// 同時実行用の制御
-	job := &JobStatus{}
-	job.totalCnt = int32(len(srcDirs))
-	job.ch = make(chan string)
-	job.wg.Add(len(srcDirs))
-	job.config = config
+	job := newJob(config, filter)
 
 	// 指定した数スレッド(goroutine)を起動
 	for i := 0; i < config.CopyThread; i++ {
-		go runWorker(srcDir, runner, job)
+		go runWorker(srcDir, runner, job, job.ch)
 	}
 
+	// 指定ディレクトリ内のディレクトリを走査(見つけた順にコピー対象として送信)
+	dirCh := make(chan string, config.ScanThread)
+	walker := newWalker(config, filter)
+	var walkErr error
+	go func() {
+		walkErr = walker.Walk(srcDir, dirCh)
+		close(dirCh)
+	}()
+
 	// コピー対象のディレクトリを送信
-	for _, name := range srcDirs {
+	finisher, hasFinish := runner.(Finisher)
+	dirs := []string{}
+	for name := range dirCh {
+		if hasFinish {
+			dirs = append(dirs, name)
+		}
+		job.AddTotal()
+		job.wg.Add(1)
 		job.ch <- name
 	}
+	close(job.ch)
 
 	// 処理待ち
 	job.wg.Wait()
+	if walkErr != nil {
+		slog.Error("ディレクトリ取得", "ERROR", walkErr, "basePath", srcDir)
+		return walkErr
+	}
+
+	// 走査できなかったディレクトリを記録
+	for _, dirErr := range walker.Errors() {
+		job.AddErrorDirs(dirErr.Path)
+	}
 
 	// エラーリトライ
 	if config.Retry {
 		runRetry(srcDir, runner, job)
 	}
 
+	// 全ディレクトリの処理後の処理
+	if hasFinish {
+		err = finisher.Finish(srcDir, dirs, job)
+		if err != nil {
+			slog.Error("Finish", "ERROR", err, "basePath", srcDir)
+		}
+	}
+
+	if !config.Quiet {
+		printSummary(start, job)
+	}
+	return nil
+}
+
+// 同時実行用の制御を作成する
+func newJob(config *Config, filter *filecopy.Filter) *JobStatus {
+	job := &JobStatus{}
+	job.ch = make(chan string)
+	job.config = config
+	job.filter = filter
+	job.backup = newBackup(config)
+	return job
+}
+
+// 集計結果を出力する
+func printSummary(start time.Time, job *JobStatus) {
 	// 処理時間を取得
 	end := time.Now()
 	duration := end.Sub(start)
@@ -75,25 +165,161 @@ func RunMecha(srcDir string, runner Runner, config *Config) error {
 	minutes := int(duration.Minutes()) % 60
 	seconds := int(duration.Seconds()) % 60
 
-	errCnt := int32(len(job.errorFiles))
+	errCnt := int32(len(job.errorFiles) + len(job.backupErrors))
+	errDirCnt := int32(len(job.errorDirs))
 	slog.Info("All File Finished")
 	fmt.Printf("処理時間: %02d時間 %02d分 %02d秒\n", hours, minutes, seconds)
-	fmt.Printf("    Total:   %d\n", job.successFileCnt+job.skipFileCnt+errCnt)
+	fmt.Printf("    Total:   %d\n", job.successFileCnt+job.linkFileCnt+job.skipFileCnt+errCnt)
 	fmt.Printf("    Success: %d\n", job.successFileCnt)
+	if job.linkFileCnt > 0 {
+		fmt.Printf("    Link:    %d\n", job.linkFileCnt)
+	}
 	fmt.Printf("    Skip:    %d\n", job.skipFileCnt)
+	for _, reason := range skipReasons {
+		if cnt := job.skipCnt[reason]; cnt > 0 {
+			fmt.Printf("      %-8s %d\n", reason+":", cnt)
+		}
+	}
 	fmt.Printf("    Error:   %d\n", errCnt)
+	fmt.Printf("    ErrDir:  %d\n", errDirCnt)
+	if len(job.rows) > 0 {
+		fmt.Printf("    %-40s %8s %8s %8s\n", job.rowTitle, "Success", "Skip", "Error")
+		for _, row := range job.rows {
+			fmt.Printf("    %-40s %8d %8d %8d\n", row.name, row.successCnt, row.skipCnt, int32(len(row.errorFiles))+row.errorCnt)
+		}
+	}
 
 	if errCnt > 0 {
-		fmt.Printf("ERROR Files")
+		fmt.Printf("ERROR Files\n")
 		for _, file := range job.errorFiles {
 			fmt.Printf("  %s\n", file)
 		}
 	}
-	return nil
+	for _, row := range job.rows {
+		if len(row.errorFiles) > 0 {
+			fmt.Printf("ERROR Files (%s)\n", row.name)
+			for _, file := range row.errorFiles {
+				fmt.Printf("  %s\n", file)
+			}
+		}
+	}
+	if errDirCnt > 0 {
+		fmt.Printf("ERROR Directories\n")
+		for _, dir := range job.errorDirs {
+			fmt.Printf("  %s\n", dir)
+		}
+	}
+	if len(job.backupErrors) > 0 {
+		fmt.Printf("BACKUP ERROR Files (退避できないため上書きしていません)\n")
+		for _, file := range job.backupErrors {
+			fmt.Printf("  %s\n", file)
+		}
+	}
+	if len(job.collisionFiles) > 0 {
+		fmt.Printf("COLLISION Files (先に指定したコピー元を優先しています)\n")
+		for _, file := range job.collisionFiles {
+			fmt.Printf("  %s\n", file)
+		}
+	}
+	if len(job.refusedFiles) > 0 {
+		fmt.Printf("REFUSED Files (計画後にコピー元、コピー先が変更されています)\n")
+		for _, file := range job.refusedFiles {
+			fmt.Printf("  %s\n", file)
+		}
+	}
+	if len(job.wipeErrorFiles) > 0 {
+		fmt.Printf("NOT WIPED Files (内容が残っている可能性があります)\n")
+		for _, file := range job.wipeErrorFiles {
+			fmt.Printf("  %s\n", file)
+		}
+	}
+}
+
+// 設定に合わせてコピー対象ファイルの判定を作成する
+func newFilter(srcDir string, config *Config) (*filecopy.Filter, error) {
+	filter, err := filecopy.NewFilter(config.TargetFiles, config.ExcludeFiles, config.ExcludeDirs)
+	if err != nil {
+		return nil, err
+	}
+	filter.MinSize = config.MinSize
+	filter.MaxSize = config.MaxSize
+	filter.MinAge = config.MinAge
+	filter.MaxAge = config.MaxAge
+
+	// ルールファイルを読み込む(後に指定したファイルを優先)
+	for _, file := range config.FilterFrom {
+		rules, err := filecopy.LoadRules(file)
+		if err != nil {
+			return nil, err
+		}
+		filter.AddRules(rules)
+	}
+
+	// ディレクトリごとのルールファイル
+	if config.IgnoreFile != "" {
+		filter.UseIgnoreFile(srcDir, config.IgnoreFile)
+	}
+	return filter, nil
+}
+
+// 対象ファイル(パターン、サイズ、更新日時)かチェックし、対象外の場合はスキップとして記録する
+// (ファイル情報が取得できない場合はnilを返し、対象として扱う)
+func selectFile(path, relFile string, entry os.DirEntry, job *JobStatus) (os.FileInfo, bool) {
+	info, reason, ok := checkFile(job.filter, path, relFile, entry)
+	if !ok {
+		job.AddSkipFile(reason)
+	}
+	return info, ok
+}
+
+// 対象ファイル(パターン、サイズ、更新日時)かチェックし、対象外の場合はスキップ理由を返す
+// (シンボリックリンクはリンク先のサイズ、更新日時でチェックする)
+func checkFile(filter *filecopy.Filter, path, relFile string, entry os.DirEntry) (os.FileInfo, SkipReason, bool) {
+	if !filter.IsCopyFile(relFile) {
+		return nil, SkipPattern, false
+	}
+
+	info, err := entryInfo(path, entry)
+	if err != nil {
+		return nil, "", true
+	}
+	if !filter.IsTargetSize(info.Size()) {
+		return info, SkipSize, false
+	}
+	if !filter.IsTargetAge(info.ModTime()) {
+		return info, SkipAge, false
+	}
+	return info, "", true
+}
+
+// ファイル情報を取得する(シンボリックリンクはリンク先の情報)
+func entryInfo(path string, entry os.DirEntry) (os.FileInfo, error) {
+	if entry.Type()&os.ModeSymlink != 0 {
+		return os.Stat(path)
+	}
+	return entry.Info()
+}
+
+// 設定に合わせてバックアップを作成する(バックアップしない場合はnil)
+func newBackup(config *Config) *filecopy.Backup {
+	if !config.Backup && config.BackupDir == "" && !config.BackupTimestamp {
+		return nil
+	}
+	return filecopy.NewBackup(config.BackupDir, config.BackupTimestamp, config.BackupKeep)
+}
+
+// 設定に合わせてディレクトリ走査を作成する
+func newWalker(config *Config, filter *filecopy.Filter) *directory.Walker {
+	walker := directory.NewWalker(config.ScanThread)
+	walker.MaxDepth = config.MaxDepth
+	walker.OneFileSystem = config.OneFS
+	walker.SkipDir = filter.IsExcludeDir
+	walker.VisitDir = filter.LoadDir
+	return walker
 }
 
 // エラーとなったファイルのコピーをリトライ
-func runRetry(srcDir string, runner Runner, job *JobStatus) {
+func runRetry(srcDir string, runner retrier, job *JobStatus) {
 	for i := 0; i < job.config.RetryCount; i++ {
 		errCount := len(job.errorFiles)
 		if errCount == 0 {
@@ -113,21 +339,21 @@ func runRetry(srcDir string, runner Runner, job *JobStatus) {
 
 		// 指定した数スレッド(goroutine)を起動
 		for i := 0; i < job.config.CopyThread; i++ {
-			go retryWorker(srcDir, runner, job)
+			go retryWorker(srcDir, runner, job, job.ch)
 		}
 
 		// コピー処理を実行
 		for _, file := range errFiles {
 			job.ch <- file
 		}
+		close(job.ch)
 		job.wg.Wait()
 	}
 }
 
 // 非同期でコピーを行う
-func retryWorker(srcDir string, runner Runner, job *JobStatus) {
-	for {
-		targetFile := <-job.ch
+func retryWorker(srcDir string, runner retrier, job *JobStatus, ch <-chan string) {
+	for targetFile := range ch {
 		err := runner.Retry(srcDir, targetFile)
 		if err != nil {
 			slog.Error("File Copy", "file", targetFile, "ERROR", err)
@@ -138,9 +364,8 @@ func retryWorker(srcDir string, runner Runner, job *JobStatus) {
 }
 
 // ファイルをコピーするワーカー(同時実行制御)
-func runWorker(baseDir string, runner Runner, job *JobStatus) {
-	for {
-		srcDir := <-job.ch
+func runWorker(baseDir string, runner Runner, job *JobStatus, ch <-chan string) {
+	for srcDir := range ch {
 		err := runner.Run(baseDir, srcDir, job)
 		if err != nil {
 			job.AddError()
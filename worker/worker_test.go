@@ -1,15 +1,24 @@
 package worker
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/coco-papiyon/mechacopy/filecopy"
 	"github.com/coco-papiyon/mechacopy/testutil"
+	"github.com/coco-papiyon/mechacopy/trash"
+	"github.com/coco-papiyon/mechacopy/watch"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -128,6 +137,272 @@ func TestCopyFiles(t *testing.T) {
 	}
 }
 
+// 除外パターンに一致するファイル、ディレクトリはコピーしない
+func TestCopyFilesExclude(t *testing.T) {
+	tt := testutil.TestCase{
+		TestFiles:  []string{"file1.txt", "logs/a.txt", "src/app/config.yaml"},
+		ExtraFiles: []string{"logs/2026/a.gz", "node_modules/a.txt", "src/app/sub/config.yaml", "file2.log"},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 準備
+	srcDir := filepath.Join(testDir, "src")
+	dstDir := filepath.Join(testDir, "dst")
+	testutil.PrepareDirs(t, tt, srcDir)
+
+	config := InitConfig()
+	config.TargetFiles = []string{"*.txt", "*.gz", "src/*/config.yaml"}
+	config.ExcludeFiles = []string{"logs/**/*.gz"}
+	config.ExcludeDirs = []string{"node_modules"}
+	var runner Runner = &CopyRunner{
+		Destination: dstDir,
+	}
+
+	// コピー実行
+	err := RunMecha(srcDir, runner, config)
+	for _, file := range tt.TestFiles {
+		src := filepath.Join(srcDir, file)
+		dst := filepath.Join(dstDir, file)
+		testutil.CheckCopy(t, src, dst, err)
+	}
+	for _, file := range tt.ExtraFiles {
+		assert.NoFileExists(t, filepath.Join(dstDir, file), "除外ファイル %s", file)
+	}
+
+	// 不正なパターン
+	config.TargetFiles = []string{"[a-"}
+	err = RunMecha(srcDir, runner, config)
+	assert.Error(t, err, "不正なパターン")
+}
+
+// ルールファイル、ディレクトリごとのルールファイルで除外する
+func TestCopyFilesFilterFrom(t *testing.T) {
+	tt := testutil.TestCase{
+		TestFiles:  []string{"file1.txt", "a/keep.log", "a/b/file2.txt"},
+		ExtraFiles: []string{"file1.log", "a/file2.log", "a/tmp/file3.txt", "cache/file4.txt"},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 準備
+	srcDir := filepath.Join(testDir, "src")
+	dstDir := filepath.Join(testDir, "dst")
+	testutil.PrepareDirs(t, tt, srcDir)
+	filterFile := filepath.Join(testDir, "filter.txt")
+	assert.NoError(t, os.WriteFile(filterFile, []byte("*.log\ncache/\n"), 0644))
+	ignoreFile := filepath.Join(srcDir, "a", ".mechaignore")
+	assert.NoError(t, os.WriteFile(ignoreFile, []byte("!keep.log\ntmp/\n"), 0644))
+
+	config := InitConfig()
+	config.FilterFrom = []string{filterFile}
+	config.IgnoreFile = ".mechaignore"
+	var runner Runner = &CopyRunner{
+		Destination: dstDir,
+	}
+
+	// コピー実行
+	err := RunMecha(srcDir, runner, config)
+	for _, file := range tt.TestFiles {
+		src := filepath.Join(srcDir, file)
+		dst := filepath.Join(dstDir, file)
+		testutil.CheckCopy(t, src, dst, err)
+	}
+	for _, file := range tt.ExtraFiles {
+		assert.NoFileExists(t, filepath.Join(dstDir, file), "除外ファイル %s", file)
+	}
+}
+
+// サイズ、更新日時が対象範囲外のファイルはコピーしない
+func TestCopyFilesSizeAge(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 準備
+	srcDir := filepath.Join(testDir, "src")
+	dstDir := filepath.Join(testDir, "dst")
+	tt := testutil.TestCase{
+		TestFiles: []string{"new.txt"},
+	}
+	testutil.PrepareDirs(t, tt, srcDir)
+	big := filepath.Join(srcDir, "big.txt")
+	assert.NoError(t, os.WriteFile(big, make([]byte, 4096), 0644))
+	old := filepath.Join(srcDir, "old.txt")
+	assert.NoError(t, testutil.CreateTestFile(old))
+	oldTime := time.Now().AddDate(0, 0, -100)
+	assert.NoError(t, os.Chtimes(old, oldTime, oldTime))
+
+	// シンボリックリンクはリンク先のサイズ、更新日時で判定する
+	outside, err := filepath.Abs(filepath.Join(testDir, "outside"))
+	assert.NoError(t, err)
+	assert.NoError(t, os.MkdirAll(outside, os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(outside, "big.txt"), make([]byte, 4096), 0644))
+	assert.NoError(t, testutil.CreateTestFile(filepath.Join(outside, "old.txt")))
+	assert.NoError(t, os.Chtimes(filepath.Join(outside, "old.txt"), oldTime, oldTime))
+	assert.NoError(t, os.Symlink(filepath.Join(outside, "big.txt"), filepath.Join(srcDir, "big_link.txt")))
+	assert.NoError(t, os.Symlink(filepath.Join(outside, "old.txt"), filepath.Join(srcDir, "old_link.txt")))
+
+	config := InitConfig()
+	config.MaxSize = 1024
+	config.MaxAge = 90 * 24 * time.Hour
+	filter, err := newFilter(srcDir, config)
+	assert.NoError(t, err, "newFilter")
+	job := &JobStatus{config: config, filter: filter}
+
+	// コピー実行
+	err = copyFiles(srcDir, ".", dstDir, job)
+	testutil.CheckCopy(t, filepath.Join(srcDir, "new.txt"), filepath.Join(dstDir, "new.txt"), err)
+	assert.NoFileExists(t, filepath.Join(dstDir, "big.txt"), "サイズ超過")
+	assert.NoFileExists(t, filepath.Join(dstDir, "old.txt"), "古いファイル")
+	assert.NoFileExists(t, filepath.Join(dstDir, "big_link.txt"), "リンク先のサイズ超過")
+	assert.NoFileExists(t, filepath.Join(dstDir, "old_link.txt"), "リンク先が古いファイル")
+	assert.Equal(t, int32(2), job.skipCnt[SkipSize], "スキップ理由(サイズ)")
+	assert.Equal(t, int32(2), job.skipCnt[SkipAge], "スキップ理由(更新日時)")
+}
+
+// 上書きするファイルを退避する
+func TestCopyFilesBackup(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 準備
+	srcDir := filepath.Join(testDir, "src")
+	dstDir := filepath.Join(testDir, "dst")
+	tt := testutil.TestCase{
+		TestFiles: []string{"file1.txt", "file2.txt"},
+	}
+	testutil.PrepareDirs(t, tt, srcDir)
+	oldFile := filepath.Join(dstDir, "file1.txt")
+	assert.NoError(t, os.MkdirAll(dstDir, 0755))
+	assert.NoError(t, os.WriteFile(oldFile, []byte("old"), 0644))
+	oldTime := time.Now().AddDate(0, 0, -1)
+	assert.NoError(t, os.Chtimes(oldFile, oldTime, oldTime))
+
+	config := InitConfig()
+	config.BackupDir = filepath.Join(testDir, "backup")
+	var runner Runner = &CopyRunner{
+		Destination: dstDir,
+	}
+
+	// コピー実行
+	err := RunMecha(srcDir, runner, config)
+	for _, file := range tt.TestFiles {
+		testutil.CheckCopy(t, filepath.Join(srcDir, file), filepath.Join(dstDir, file), err)
+	}
+	data, err := os.ReadFile(filepath.Join(config.BackupDir, "file1.txt"))
+	assert.NoError(t, err, "退避先")
+	assert.Equal(t, "old", string(data), "退避したファイル")
+	assert.NoFileExists(t, filepath.Join(config.BackupDir, "file2.txt"), "新規ファイルは退避しない")
+
+	// 退避できない場合はリトライでも上書きしない
+	assert.NoError(t, os.WriteFile(oldFile, []byte("old"), 0644))
+	assert.NoError(t, os.Chtimes(oldFile, oldTime, oldTime))
+	config.BackupDir = filepath.Join(testDir, "backup_file")
+	assert.NoError(t, os.WriteFile(config.BackupDir, nil, 0644))
+	config.Quiet = true
+	config.SleepTime = 0
+	config.RetryCount = 1
+	filter, err := newFilter(srcDir, config)
+	assert.NoError(t, err)
+	job := newJob(config, filter)
+	go runWorker(srcDir, runner, job, job.ch)
+	job.wg.Add(1)
+	job.ch <- "."
+	close(job.ch)
+	job.wg.Wait()
+	runRetry(srcDir, runner, job)
+	data, err = os.ReadFile(oldFile)
+	assert.NoError(t, err)
+	assert.Equal(t, "old", string(data), "退避できないファイル")
+	assert.Equal(t, []string{"file1.txt"}, job.backupErrors, "退避できなかったファイル")
+	assert.Empty(t, job.errorFiles, "リトライ対象")
+}
+
+// 前回のスナップショットと差分がないファイルはハードリンクにする
+func TestCopyFilesLinkDest(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 準備
+	srcDir := filepath.Join(testDir, "src")
+	prevDir := filepath.Join(testDir, "dst", "1")
+	dstDir := filepath.Join(testDir, "dst", "2")
+	tt := testutil.TestCase{
+		TestFiles: []string{"file1.txt", "a/file2.txt"},
+	}
+	testutil.PrepareDirs(t, tt, srcDir)
+
+	config := InitConfig()
+	err := RunMecha(srcDir, &CopyRunner{Destination: prevDir}, config)
+	assert.NoError(t, err, "前回のスナップショット")
+	changed := filepath.Join(srcDir, "a", "file2.txt")
+	assert.NoError(t, os.WriteFile(changed, []byte("changed"), 0644))
+
+	// コピー実行
+	config.LinkDest = prevDir
+	err = RunMecha(srcDir, &CopyRunner{Destination: dstDir}, config)
+	for _, file := range tt.TestFiles {
+		testutil.CheckCopy(t, filepath.Join(srcDir, file), filepath.Join(dstDir, file), err)
+	}
+
+	prevInfo, _ := os.Stat(filepath.Join(prevDir, "file1.txt"))
+	dstInfo, _ := os.Stat(filepath.Join(dstDir, "file1.txt"))
+	assert.True(t, os.SameFile(prevInfo, dstInfo), "差分がないファイルはハードリンク")
+	prevInfo, _ = os.Stat(filepath.Join(prevDir, "a", "file2.txt"))
+	dstInfo, _ = os.Stat(filepath.Join(dstDir, "a", "file2.txt"))
+	assert.False(t, os.SameFile(prevInfo, dstInfo), "変更されたファイルはコピー")
+}
+
+func TestSkipOverwrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		diff   filecopy.FileDiff
+		reason SkipReason
+		skip   bool
+	}{
+		{"same", Config{}, filecopy.DiffSame, SkipSame, true},
+		{"missing", Config{NoOverwrite: true}, filecopy.DiffMissing, "", false},
+		{"default_older", Config{}, filecopy.DiffOlder, "", false},
+		{"default_changed", Config{}, filecopy.DiffChanged, "", false},
+		{"XO", Config{ExcludeOlder: true}, filecopy.DiffOlder, SkipOlder, true},
+		{"XO_newer", Config{ExcludeOlder: true}, filecopy.DiffNewer, "", false},
+		{"XN", Config{ExcludeNewer: true}, filecopy.DiffNewer, SkipNewer, true},
+		{"XC", Config{ExcludeChanged: true}, filecopy.DiffChanged, SkipChanged, true},
+		{"no_overwrite", Config{NoOverwrite: true}, filecopy.DiffNewer, SkipExist, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, skip := skipOverwrite(tt.diff, &tt.config)
+			assert.Equal(t, tt.skip, skip, "skip")
+			assert.Equal(t, tt.reason, reason, "reason")
+		})
+	}
+}
+
 func TestIsCopyFile(t *testing.T) {
 	tests := []struct {
 		target  []string
@@ -199,3 +474,1110 @@ func TestCopyRetry(t *testing.T) {
 		testutil.CheckCopy(t, src, dst, nil)
 	}
 }
+
+// 対象ファイルのみ削除し、空になったディレクトリを削除する
+func TestDeleteFiles(t *testing.T) {
+	tt := testutil.TestCase{
+		TestDirs:   []string{"empty"},
+		TestFiles:  []string{"file1.log", "a/file2.log", "a/b/file3.log", "c/file4.log"},
+		ExtraFiles: []string{"file1.txt", "c/file5.txt"},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 準備
+	srcDir := filepath.Join(testDir, "src")
+	testutil.PrepareDirs(t, tt, srcDir)
+
+	config := InitConfig()
+	config.TargetFiles = []string{"*.log"}
+	config.Retry = false
+
+	// 削除実行
+	err := RunMecha(srcDir, &DeleteRunner{}, config)
+	assert.NoError(t, err, "RunMecha")
+	for _, file := range tt.TestFiles {
+		assert.NoFileExists(t, filepath.Join(srcDir, file), "削除対象 %s", file)
+	}
+	for _, file := range tt.ExtraFiles {
+		assert.FileExists(t, filepath.Join(srcDir, file), "削除対象外 %s", file)
+	}
+	assert.NoDirExists(t, filepath.Join(srcDir, "a"), "空になったディレクトリ")
+	assert.DirExists(t, filepath.Join(srcDir, "c"), "空でないディレクトリ")
+	assert.DirExists(t, filepath.Join(srcDir, "empty"), "元から空のディレクトリ")
+
+	// すべて削除
+	config.TargetFiles = []string{"*"}
+	err = RunMecha(srcDir, &DeleteRunner{RemoveEmptyDirs: true}, config)
+	assert.NoError(t, err, "RunMecha")
+	entries, err := os.ReadDir(srcDir)
+	assert.NoError(t, err, "起点ディレクトリは削除しない")
+	assert.Empty(t, entries, "すべて削除")
+}
+
+// 削除できないディレクトリはエラーとして記録する
+func TestDeleteDirError(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root ではパーミッションエラーを再現できない")
+	}
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	srcDir := filepath.Join(testDir, "src")
+	assert.NoError(t, os.MkdirAll(filepath.Join(srcDir, "a", "b"), os.ModePerm))
+	assert.NoError(t, os.Chmod(filepath.Join(srcDir, "a"), 0555))
+	defer os.Chmod(filepath.Join(srcDir, "a"), 0755)
+
+	job := newJob(InitConfig(), nil)
+	runner := &DeleteRunner{RemoveEmptyDirs: true}
+	assert.NoError(t, runner.Finish(srcDir, []string{".", "a", filepath.Join("a", "b")}, job))
+	assert.Equal(t, []string{filepath.Join("a", "b")}, job.errorDirs, "削除できないディレクトリ")
+	assert.Equal(t, 1, runner.Failed(), "削除できなかった数")
+	assert.DirExists(t, srcDir, "起点ディレクトリ")
+}
+
+// 削除せずにゴミ箱へ移動する
+func TestDeleteFilesTrash(t *testing.T) {
+	tt := testutil.TestCase{
+		TestFiles: []string{"file1.log", "a/file2.log"},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 準備
+	srcDir := filepath.Join(testDir, "src")
+	trashDir := filepath.Join(testDir, "trash")
+	testutil.PrepareDirs(t, tt, srcDir)
+
+	config := InitConfig()
+	config.Retry = false
+
+	// 削除実行
+	err := RunMecha(srcDir, &DeleteRunner{Trash: trash.New(trashDir)}, config)
+	assert.NoError(t, err, "RunMecha")
+	for _, file := range tt.TestFiles {
+		assert.NoFileExists(t, filepath.Join(srcDir, file), "削除対象 %s", file)
+		assert.FileExists(t, filepath.Join(trashDir, "files", file), "ゴミ箱 %s", file)
+	}
+}
+
+// 内容を上書きしてから削除する
+func TestDeleteFilesWipe(t *testing.T) {
+	tt := testutil.TestCase{
+		TestFiles: []string{"file1.log", "a/file2.log"},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// 準備
+	srcDir := filepath.Join(testDir, "src")
+	testutil.PrepareDirs(t, tt, srcDir)
+	config := InitConfig()
+	filter, err := newFilter(srcDir, config)
+	assert.NoError(t, err, "newFilter")
+	job := &JobStatus{config: config, filter: filter}
+	runner := &DeleteRunner{Wipe: &filecopy.Wipe{Passes: 1, Random: true}}
+
+	// 削除対象の外を指すシンボリックリンク
+	outside := filepath.Join(testDir, "outside.log")
+	assert.NoError(t, os.WriteFile(outside, []byte("outside"), 0644))
+	link := filepath.Join(srcDir, "a", "link.log")
+	assert.NoError(t, os.Symlink(filepath.Join("..", "..", "outside.log"), link))
+
+	// 削除実行
+	for _, dir := range []string{".", "a"} {
+		assert.NoError(t, runner.Run(srcDir, dir, job), "Run %s", dir)
+	}
+	for _, file := range tt.TestFiles {
+		assert.NoFileExists(t, filepath.Join(srcDir, file), "削除対象 %s", file)
+	}
+	assert.Equal(t, int32(3), job.successFileCnt, "削除したファイル数")
+	assert.Len(t, job.wipeErrorFiles, 0, "上書きできなかったファイル")
+
+	// リンクのみ削除し、リンク先は上書きしない
+	_, err = os.Lstat(link)
+	assert.True(t, os.IsNotExist(err), "シンボリックリンク")
+	data, err := os.ReadFile(outside)
+	assert.NoError(t, err)
+	assert.Equal(t, "outside", string(data), "リンク先")
+}
+
+// 空のディレクトリのみ削除する
+func TestRemoveEmptyDirs(t *testing.T) {
+	tt := testutil.TestCase{
+		TestDirs:   []string{"empty", "a/b/c", "keep/x", "junk/sub"},
+		TestFiles:  []string{"file1.txt", "keep/file2.txt", "junk/Thumbs.db", "junk/sub/.DS_Store"},
+		ExtraDirs:  []string{"node_modules/empty"},
+		ExtraFiles: []string{"junk2/Thumbs.db"},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 準備
+	srcDir := filepath.Join(testDir, "src")
+	testutil.PrepareDirs(t, tt, srcDir)
+
+	config := InitConfig()
+	config.ExcludeDirs = []string{"node_modules"}
+	dirs, files, err := CountEmptyDirs(srcDir, config, []string{"Thumbs.db", ".DS_Store"})
+	assert.NoError(t, err, "CountEmptyDirs")
+	assert.Equal(t, int64(8), dirs, "削除対象のディレクトリ数")
+	assert.Equal(t, int64(3), files, "削除対象の無視するファイル数")
+	assert.DirExists(t, filepath.Join(srcDir, "empty"), "数えるだけで削除しない")
+
+	removed, errDirs, err := RemoveEmptyDirs(srcDir, config, []string{"Thumbs.db", ".DS_Store"})
+	assert.NoError(t, err, "RemoveEmptyDirs")
+	assert.Len(t, errDirs, 0, "エラー")
+	assert.Equal(t, 8, removed, "削除したディレクトリ数")
+
+	for _, dir := range []string{"empty", "a", "keep/x", "junk", "junk2"} {
+		assert.NoDirExists(t, filepath.Join(srcDir, dir), "空ディレクトリ %s", dir)
+	}
+	assert.FileExists(t, filepath.Join(srcDir, "keep", "file2.txt"), "空でないディレクトリ")
+	assert.DirExists(t, filepath.Join(srcDir, "node_modules", "empty"), "除外ディレクトリ")
+	assert.DirExists(t, srcDir, "起点ディレクトリ")
+}
+
+func TestCheckDeleteTarget(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	tt := testutil.TestCase{
+		TestDirs: []string{"protected/sub", "other"},
+	}
+	testutil.PrepareDirs(t, tt, testDir)
+	protected := []string{filepath.Join(testDir, "protected")}
+
+	assert.Error(t, CheckDeleteTarget("/", protected), "ファイルシステムのルート")
+	assert.Error(t, CheckDeleteTarget(filepath.Join(testDir, "protected"), protected), "保護対象")
+	assert.Error(t, CheckDeleteTarget(testDir, protected), "保護対象の上位ディレクトリ")
+	assert.NoError(t, CheckDeleteTarget(filepath.Join(testDir, "protected", "sub"), protected), "保護対象の下位ディレクトリ")
+	assert.NoError(t, CheckDeleteTarget(filepath.Join(testDir, "other"), protected), "保護対象外")
+}
+
+func TestCountTargetFiles(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	tt := testutil.TestCase{
+		TestFiles: []string{"file1.log", "a/file2.log", "a/file3.txt"},
+	}
+	testutil.PrepareDirs(t, tt, srcDir)
+	var size int64
+	for _, file := range tt.TestFiles[:2] {
+		info, err := os.Stat(filepath.Join(srcDir, file))
+		assert.NoError(t, err, "Stat")
+		size += info.Size()
+	}
+
+	config := InitConfig()
+	config.TargetFiles = []string{"*.log"}
+	files, bytes, err := CountTargetFiles(srcDir, config)
+	assert.NoError(t, err, "CountTargetFiles")
+	assert.Equal(t, int64(2), files, "ファイル数")
+	assert.Equal(t, size, bytes, "合計サイズ")
+}
+
+// 2つのディレクトリを比較する
+func TestDiffRunner(t *testing.T) {
+	tt := testutil.TestCase{
+		TestFiles: []string{"same.txt", "size.txt", "mtime.txt", "content.txt", "src_only.txt", "a/src_only.txt"},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 準備
+	srcDir := filepath.Join(testDir, "src")
+	dstDir := filepath.Join(testDir, "dst")
+	testutil.PrepareDirs(t, tt, srcDir)
+	config := InitConfig()
+	config.Quiet = true
+	for _, file := range tt.TestFiles[:4] {
+		assert.NoError(t, filecopy.CopyFile(filepath.Join(srcDir, file), filepath.Join(dstDir, file)))
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(dstDir, "size.txt"), []byte("size"), 0644))
+	mtime := time.Now().Add(-time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(dstDir, "mtime.txt"), mtime, mtime))
+
+	// 更新日時の精度が粗いファイルシステム(コピー先の方が新しい)、シンボリックリンクは差分なし
+	srcInfo, err := os.Stat(filepath.Join(srcDir, "same.txt"))
+	assert.NoError(t, err)
+	coarse := srcInfo.ModTime().Add(time.Second)
+	assert.NoError(t, filecopy.CopyFile(filepath.Join(srcDir, "same.txt"), filepath.Join(srcDir, "coarse.txt")))
+	assert.NoError(t, filecopy.CopyFile(filepath.Join(srcDir, "same.txt"), filepath.Join(dstDir, "coarse.txt")))
+	assert.NoError(t, os.Chtimes(filepath.Join(dstDir, "coarse.txt"), coarse, coarse))
+	assert.NoError(t, filecopy.CopyFile(filepath.Join(srcDir, "same.txt"), filepath.Join(srcDir, "link.txt")))
+	linkTarget, err := filepath.Abs(filepath.Join(testDir, "link_target.txt"))
+	assert.NoError(t, err)
+	assert.NoError(t, filecopy.CopyFile(filepath.Join(srcDir, "same.txt"), linkTarget))
+	assert.NoError(t, os.Symlink(linkTarget, filepath.Join(dstDir, "link.txt")))
+	data, _ := os.ReadFile(filepath.Join(srcDir, "content.txt"))
+	data[0]++
+	info, _ := os.Stat(filepath.Join(srcDir, "content.txt"))
+	assert.NoError(t, os.WriteFile(filepath.Join(dstDir, "content.txt"), data, 0644))
+	assert.NoError(t, os.Chtimes(filepath.Join(dstDir, "content.txt"), info.ModTime(), info.ModTime()))
+	assert.NoError(t, testutil.CreateTestFile(filepath.Join(dstDir, "dst_only.txt")))
+	assert.NoError(t, testutil.CreateTestFile(filepath.Join(dstDir, "b", "c", "dst_only.txt")))
+
+	tests := []struct {
+		hash  bool
+		diffs map[string]DiffKind
+	}{
+		{false, map[string]DiffKind{
+			"size.txt":         DiffSize,
+			"mtime.txt":        DiffModTime,
+			"src_only.txt":     DiffOnlySource,
+			"a/src_only.txt":   DiffOnlySource,
+			"dst_only.txt":     DiffOnlyDestination,
+			"b/c/dst_only.txt": DiffOnlyDestination,
+		}},
+		{true, map[string]DiffKind{
+			"size.txt":         DiffSize,
+			"mtime.txt":        DiffModTime,
+			"content.txt":      DiffContent,
+			"src_only.txt":     DiffOnlySource,
+			"a/src_only.txt":   DiffOnlySource,
+			"dst_only.txt":     DiffOnlyDestination,
+			"b/c/dst_only.txt": DiffOnlyDestination,
+		}},
+	}
+	for _, tc := range tests {
+		t.Run(fmt.Sprintf("hash=%v", tc.hash), func(t *testing.T) {
+			runner := &DiffRunner{Destination: dstDir, Hash: tc.hash}
+			err := RunMecha(srcDir, runner, config)
+			assert.NoError(t, err, "RunMecha")
+
+			report := runner.Report()
+			assert.False(t, report.Match, "Match")
+			diffs := map[string]DiffKind{}
+			for _, diff := range report.Differences {
+				diffs[filepath.ToSlash(diff.Path)] = diff.Kind
+			}
+			assert.Equal(t, tc.diffs, diffs, "差分")
+		})
+	}
+
+	// 一致する場合
+	runner := &DiffRunner{Destination: dstDir}
+	config.TargetFiles = []string{"same.txt"}
+	err = RunMecha(srcDir, runner, config)
+	assert.NoError(t, err, "RunMecha")
+	assert.True(t, runner.Report().Match, "Match")
+}
+
+func TestChecksumRunner(t *testing.T) {
+	tt := testutil.TestCase{
+		TestFiles: []string{"ok.txt", "corrupt.txt", "missing.txt", "a/ok.txt"},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// マニフェストを作成
+	srcDir := filepath.Join(testDir, "src")
+	testutil.PrepareDirs(t, tt, srcDir)
+	config := InitConfig()
+	config.Quiet = true
+	runner := &ChecksumRunner{}
+	assert.NoError(t, RunMecha(srcDir, runner, config), "RunMecha")
+	entries := runner.Entries()
+	assert.Len(t, entries, len(tt.TestFiles), "マニフェスト")
+	assert.Equal(t, "a/ok.txt", entries[0].Path, "パス順")
+	hash, _ := filecopy.HashFile(filepath.Join(srcDir, "ok.txt"))
+
+	// sha256sum 形式、JSON形式で読み込めること
+	var text, js bytes.Buffer
+	assert.NoError(t, WriteManifest(&text, entries))
+	assert.NoError(t, WriteManifestJSON(&js, entries))
+	assert.Contains(t, text.String(), hash+"  ok.txt\n")
+	for _, buf := range []*bytes.Buffer{&text, &js} {
+		manifest, err := ReadManifest(bytes.NewReader(buf.Bytes()))
+		assert.NoError(t, err, "ReadManifest")
+		assert.Equal(t, hash, manifest["ok.txt"])
+		assert.Len(t, manifest, len(tt.TestFiles))
+	}
+	_, err := ReadManifest(strings.NewReader("invalid\n"))
+	assert.Error(t, err, "不正な形式")
+
+	// 変更してから検証
+	manifest, _ := ReadManifest(&text)
+	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "corrupt.txt"), []byte("corrupt"), 0644))
+	assert.NoError(t, os.Remove(filepath.Join(srcDir, "missing.txt")))
+	assert.NoError(t, testutil.CreateTestFile(filepath.Join(srcDir, "b", "extra.txt")))
+	runner = &ChecksumRunner{Expected: manifest}
+	assert.NoError(t, RunMecha(srcDir, runner, config), "RunMecha")
+	report := runner.Report()
+	assert.False(t, report.OK(), "OK")
+	assert.Equal(t, []string{"missing.txt"}, report.Missing, "Missing")
+	assert.Equal(t, []string{"b/extra.txt"}, report.Extra, "Extra")
+	assert.Equal(t, []string{"corrupt.txt"}, report.Corrupted, "Corrupted")
+	assert.Empty(t, report.Errors, "Errors")
+
+	// 対象外のファイル、対象ディレクトリ内のマニフェストファイルは検証しない
+	manifestFile := filepath.Join(srcDir, "manifest.sha256")
+	assert.NoError(t, os.WriteFile(manifestFile, text.Bytes(), 0644))
+	config.ExcludeDirs = []string{"a", "b"}
+	config.ExcludeFiles = []string{"ok.txt"}
+	runner = &ChecksumRunner{Expected: manifest, ManifestFiles: []string{manifestFile}}
+	assert.NoError(t, RunMecha(srcDir, runner, config), "RunMecha")
+	report = runner.Report()
+	assert.Equal(t, []string{"missing.txt"}, report.Missing, "対象外のファイルは Missing にしない")
+	assert.Empty(t, report.Extra, "マニフェストファイルは Extra にしない")
+
+	config.ExcludeDirs = nil
+	config.ExcludeFiles = nil
+	config.MaxDepth = 1
+	config.MaxSize = 1
+	runner = &ChecksumRunner{Expected: manifest, ManifestFiles: []string{manifestFile}}
+	assert.NoError(t, RunMecha(srcDir, runner, config), "RunMecha")
+	report = runner.Report()
+	assert.Equal(t, []string{"missing.txt"}, report.Missing, "階層数、サイズで対象外のファイルは Missing にしない")
+}
+
+// sha256sum と同じく '\'、改行を含むファイル名はエスケープする
+func TestManifestEscape(t *testing.T) {
+	entries := []ManifestEntry{
+		{Path: "a.txt", SHA256: "00"},
+		{Path: "new\nline.txt", SHA256: "11"},
+		{Path: `back\slash.txt`, SHA256: "22"},
+	}
+	var text bytes.Buffer
+	assert.NoError(t, WriteManifest(&text, entries))
+	assert.Equal(t, "00  a.txt\n\\11  new\\nline.txt\n\\22  back\\\\slash.txt\n", text.String())
+
+	manifest, err := ReadManifest(&text)
+	assert.NoError(t, err, "ReadManifest")
+	assert.Equal(t, map[string]string{"a.txt": "00", "new\nline.txt": "11", `back\slash.txt`: "22"}, manifest)
+}
+
+func TestReadFileList(t *testing.T) {
+	tests := []struct {
+		input string
+		files []string
+	}{
+		{"a.txt\nb/c.txt\n", []string{"a.txt", "b/c.txt"}},
+		{"a.txt\r\n\r\nb c.txt", []string{"a.txt", "b c.txt"}},
+		{"./a.txt\x00b\nc.txt\x00", []string{"./a.txt", "b\nc.txt"}},
+		{"", []string{}},
+	}
+	for _, tc := range tests {
+		files, err := ReadFileList(strings.NewReader(tc.input))
+		assert.NoError(t, err, "ReadFileList")
+		assert.Equal(t, tc.files, files, tc.input)
+	}
+}
+
+func TestRunFiles(t *testing.T) {
+	tt := testutil.TestCase{
+		TestFiles: []string{"list.txt", "other.txt", "a/list.txt", "a/b/list.txt", "x/list.txt"},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 準備
+	srcDir := filepath.Join(testDir, "src")
+	dstDir := filepath.Join(testDir, "dst")
+	testutil.PrepareDirs(t, tt, srcDir)
+	config := InitConfig()
+	config.Quiet = true
+	config.Retry = false
+	config.ExcludeDirs = []string{"x"}
+	absSrc, _ := filepath.Abs(srcDir)
+	files := []string{".", "./list.txt", "a", filepath.Join(absSrc, "a", "list.txt"), "a/b/list.txt", "a/b/list.txt", "x/list.txt", "../outside.txt", "missing.txt"}
+
+	// 一覧のファイルのみコピーされること
+	err := RunFiles(srcDir, files, CopyRunner{Destination: dstDir}, config)
+	assert.NoError(t, err, "RunFiles")
+	for _, file := range tt.TestFiles {
+		_, err := os.Stat(filepath.Join(dstDir, file))
+		assert.Equal(t, file != "other.txt" && file != "x/list.txt", err == nil, file)
+	}
+	_, err = os.Stat(filepath.Join(testDir, "outside.txt"))
+	assert.True(t, os.IsNotExist(err), "コピー元の外")
+
+	for _, path := range []string{".", "a/list.txt", "a/../b", filepath.Join(absSrc, "a")} {
+		_, err := relPath(srcDir, path)
+		assert.NoError(t, err, path)
+	}
+	for _, path := range []string{"..", "../a", filepath.Join(os.TempDir(), "a")} {
+		_, err := relPath(srcDir, path)
+		assert.Error(t, err, path)
+	}
+}
+
+func TestPlanApply(t *testing.T) {
+	tt := testutil.TestCase{
+		TestFiles: []string{"new.txt", "update.txt", "changed.txt", "a/new.txt", "b/c/new.txt"},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 準備
+	srcDir := filepath.Join(testDir, "src")
+	dstDir := filepath.Join(testDir, "dst")
+	testutil.PrepareDirs(t, tt, srcDir)
+	config := InitConfig()
+	config.Quiet = true
+	config.Retry = false
+	old := time.Now().Add(-time.Hour)
+	for _, file := range []string{"update.txt", "changed.txt"} {
+		dst := filepath.Join(dstDir, file)
+		assert.NoError(t, testutil.CreateTestFile(dst))
+		assert.NoError(t, os.Chtimes(dst, old, old))
+	}
+	assert.NoError(t, testutil.CreateTestFile(filepath.Join(dstDir, "dst_only.txt")))
+	assert.NoError(t, testutil.CreateTestFile(filepath.Join(dstDir, "d", "e", "dst_only.txt")))
+
+	// 計画を作成(コピーはしない)
+	runner := &PlanRunner{Destination: dstDir, Delete: true}
+	assert.NoError(t, RunMecha(srcDir, runner, config), "RunMecha")
+	plan := runner.Plan(srcDir)
+	ops := map[string]PlanOp{}
+	for _, item := range plan.Items {
+		ops[filepath.ToSlash(item.Path)] = item.Op
+	}
+	assert.Equal(t, map[string]PlanOp{
+		"a":                PlanMkdir,
+		"b":                PlanMkdir,
+		"b/c":              PlanMkdir,
+		"new.txt":          PlanCopy,
+		"a/new.txt":        PlanCopy,
+		"b/c/new.txt":      PlanCopy,
+		"update.txt":       PlanOverwrite,
+		"changed.txt":      PlanOverwrite,
+		"dst_only.txt":     PlanDelete,
+		"d":                PlanDelete,
+		"d/e":              PlanDelete,
+		"d/e/dst_only.txt": PlanDelete,
+	}, ops, "計画")
+	assert.Equal(t, PlanMkdir, plan.Items[0].Op, "操作の順序")
+	_, err := os.Stat(filepath.Join(dstDir, "new.txt"))
+	assert.True(t, os.IsNotExist(err), "コピーしない")
+
+	// 書き出した計画を読み込めること
+	var buf bytes.Buffer
+	assert.NoError(t, WritePlan(&buf, plan))
+	plan, err = ReadPlan(&buf)
+	assert.NoError(t, err, "ReadPlan")
+	_, err = ReadPlan(strings.NewReader(`{"source":"s","destination":"d","items":[{"op":"copy","path":"../x"}]}`))
+	assert.Error(t, err, "コピー元の外")
+
+	// 計画後に変更されたファイル、作成されたコピー先は操作しない(エラーを返す)
+	changed := filepath.Join(srcDir, "changed.txt")
+	assert.NoError(t, os.WriteFile(changed, []byte("changed after plan"), 0644))
+	appeared := filepath.Join(dstDir, "a", "new.txt")
+	assert.NoError(t, os.MkdirAll(filepath.Dir(appeared), os.ModePerm))
+	assert.NoError(t, os.WriteFile(appeared, []byte("created after plan"), 0644))
+	assert.Error(t, ApplyPlan(plan, config), "ApplyPlan")
+	data, err := os.ReadFile(appeared)
+	assert.NoError(t, err)
+	assert.Equal(t, "created after plan", string(data), "計画後に作成されたコピー先")
+	for _, file := range []string{"new.txt", "b/c/new.txt", "update.txt"} {
+		assert.False(t, filecopy.IsFileDiff(filepath.Join(srcDir, file), filepath.Join(dstDir, file)), file)
+	}
+	assert.True(t, filecopy.IsFileDiff(changed, filepath.Join(dstDir, "changed.txt")), "changed.txt")
+	for _, file := range []string{"dst_only.txt", "d"} {
+		_, err := os.Stat(filepath.Join(dstDir, file))
+		assert.True(t, os.IsNotExist(err), file)
+	}
+
+	// 計画どおりに実行できた場合はエラーを返さない
+	runner = &PlanRunner{Destination: dstDir}
+	assert.NoError(t, RunMecha(srcDir, runner, config), "RunMecha")
+	assert.NoError(t, ApplyPlan(runner.Plan(srcDir), config), "ApplyPlan")
+}
+
+func TestRunDirs(t *testing.T) {
+	tt := testutil.TestCase{
+		TestFiles: []string{"top.txt", "a/file.txt", "a/b/file.txt", "x/file.txt"},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 指定したディレクトリのみコピーされること(サブディレクトリ、除外ディレクトリは対象外)
+	srcDir := filepath.Join(testDir, "src")
+	dstDir := filepath.Join(testDir, "dst")
+	testutil.PrepareDirs(t, tt, srcDir)
+	config := InitConfig()
+	config.Quiet = true
+	config.ExcludeDirs = []string{"x"}
+	err := RunDirs(srcDir, []string{"a", "x", "deleted"}, CopyRunner{Destination: dstDir}, config)
+	assert.NoError(t, err, "RunDirs")
+	for _, file := range tt.TestFiles {
+		_, err := os.Stat(filepath.Join(dstDir, file))
+		assert.Equal(t, file == "a/file.txt", err == nil, file)
+	}
+}
+
+func TestMonitor(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	srcDir := filepath.Join(testDir, "src")
+	dstDir := filepath.Join(testDir, "dst")
+	assert.NoError(t, testutil.CreateTestFile(filepath.Join(srcDir, "first.txt")))
+	config := InitConfig()
+	config.Quiet = true
+	monitor := &Monitor{Debounce: 50 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- monitor.Run(ctx, srcDir, CopyRunner{Destination: dstDir}, config)
+	}()
+
+	// 指定したファイルがコピーされるまで待つ
+	waitCopied := func(file string) {
+		for i := 0; i < 100; i++ {
+			if !filecopy.IsFileDiff(filepath.Join(srcDir, file), filepath.Join(dstDir, file)) {
+				return
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		t.Errorf("コピーされません: %s", file)
+	}
+
+	// 初回は全体がコピーされ、以降は変更がコピーされること
+	waitCopied("first.txt")
+	assert.NoError(t, testutil.CreateTestFile(filepath.Join(srcDir, "a", "b", "new.txt")))
+	waitCopied(filepath.Join("a", "b", "new.txt"))
+
+	cancel()
+	select {
+	case err := <-done:
+		assert.NoError(t, err, "Run")
+	case <-time.After(5 * time.Second):
+		t.Error("監視が終了しません")
+	}
+}
+
+// 変更を監視できない場合は再走査しない設定でも再走査する
+func TestMonitorRescanInterval(t *testing.T) {
+	monitor := &Monitor{}
+	assert.Equal(t, time.Duration(0), monitor.rescanInterval(nil), "再走査しない")
+	assert.Equal(t, DefaultRescan, monitor.rescanInterval(watch.ErrNotSupported), "監視できない")
+	monitor.Rescan = time.Minute
+	assert.Equal(t, time.Minute, monitor.rescanInterval(watch.ErrNotSupported), "指定した間隔")
+}
+
+func TestBiSync(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	dirA := filepath.Join(testDir, "a")
+	dirB := filepath.Join(testDir, "b")
+	config := InitConfig()
+	config.Quiet = true
+	write := func(dir, file, data string, age time.Duration) {
+		path := filepath.Join(dir, file)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NoError(t, os.WriteFile(path, []byte(data), 0644))
+		mtime := time.Now().Add(-age)
+		assert.NoError(t, os.Chtimes(path, mtime, mtime))
+	}
+	read := func(dir, file string) string {
+		data, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			return ""
+		}
+		return string(data)
+	}
+	run := func(mode ConflictMode) *SyncReport {
+		bisync := &BiSync{A: dirA, B: dirB, State: filepath.Join(testDir, "state.json"), Conflict: mode}
+		report, err := bisync.Run(config)
+		assert.NoError(t, err, "Run")
+		return report
+	}
+
+	// 初回は両方のファイルをコピーする(削除はしない)
+	for _, file := range []string{"del_a.txt", "del_b.txt", "mod_a.txt", "mod_b.txt", "conflict.txt", "keep.txt"} {
+		write(dirA, file, "base", time.Hour)
+	}
+	write(dirA, "sub/only_a.txt", "a", time.Hour)
+	write(dirB, "only_b.txt", "b", time.Hour)
+	report := run(ConflictReport)
+	assert.Len(t, report.CopyAToB, 7, "A -> B")
+	assert.Equal(t, []string{"only_b.txt"}, report.CopyBToA, "A <- B")
+	assert.Equal(t, "a", read(dirB, "sub/only_a.txt"))
+	assert.Equal(t, "b", read(dirA, "only_b.txt"))
+
+	// 変更、削除を双方向に反映する
+	assert.NoError(t, os.Remove(filepath.Join(dirA, "del_a.txt")))
+	assert.NoError(t, os.Remove(filepath.Join(dirB, "del_b.txt")))
+	write(dirA, "mod_a.txt", "mod a", 0)
+	write(dirB, "mod_b.txt", "mod b", 0)
+	write(dirA, "conflict.txt", "conflict a", 2*time.Minute)
+	write(dirB, "conflict.txt", "conflict b", time.Minute)
+	write(dirA, "keep.txt", "keep a", time.Minute)
+	write(dirB, "keep.txt", "keep b", 2*time.Minute)
+	report = run(ConflictReport)
+	assert.Equal(t, []string{"mod_a.txt"}, report.CopyAToB, "A -> B")
+	assert.Equal(t, []string{"mod_b.txt"}, report.CopyBToA, "A <- B")
+	assert.Equal(t, []string{"del_b.txt"}, report.DeleteA, "DELETE A")
+	assert.Equal(t, []string{"del_a.txt"}, report.DeleteB, "DELETE B")
+	assert.Len(t, report.Conflicts, 2, "競合")
+	assert.Equal(t, "mod a", read(dirB, "mod_a.txt"))
+	assert.Equal(t, "mod b", read(dirA, "mod_b.txt"))
+	assert.Equal(t, "", read(dirA, "del_b.txt"))
+	assert.Equal(t, "", read(dirB, "del_a.txt"))
+	assert.Equal(t, "conflict a", read(dirA, "conflict.txt"), "報告のみ")
+	assert.Equal(t, "conflict b", read(dirB, "conflict.txt"), "報告のみ")
+
+	// 報告のみの競合は次回も競合として扱う
+	bisync := &BiSync{A: dirA, B: dirB, State: filepath.Join(testDir, "state.json"), Conflict: ConflictNewer, Now: time.Now()}
+	report, err := bisync.Run(config)
+	assert.NoError(t, err, "Run")
+	assert.Len(t, report.Conflicts, 2, "競合")
+	assert.Equal(t, "conflict b", read(dirA, "conflict.txt"), "新しい方")
+	assert.Equal(t, "keep a", read(dirB, "keep.txt"), "新しい方")
+
+	// 両方に残す
+	write(dirA, "keep.txt", "keep a2", 2*time.Minute)
+	write(dirB, "keep.txt", "keep b2", time.Minute)
+	bisync = &BiSync{A: dirA, B: dirB, State: filepath.Join(testDir, "state.json"), Conflict: ConflictKeepBoth, Now: time.Now()}
+	report, err = bisync.Run(config)
+	assert.NoError(t, err, "Run")
+	renamed := conflictName("keep.txt", bisync.Now)
+	assert.Equal(t, []SyncConflict{{Path: "keep.txt", Resolution: ConflictKeepBoth, Kept: "b", Renamed: renamed}}, report.Conflicts)
+	assert.Equal(t, "keep b2", read(dirA, "keep.txt"))
+	assert.Equal(t, "keep a2", read(dirA, renamed))
+	assert.Equal(t, "keep a2", read(dirB, renamed))
+
+	// 同期後は変更がないこと
+	report = run(ConflictReport)
+	assert.Equal(t, &SyncReport{}, report, "変更なし")
+
+	// 片方が空になった場合は削除を反映しない
+	assert.NoError(t, os.Rename(dirB, dirB+".bak"))
+	assert.NoError(t, os.Mkdir(dirB, os.ModePerm))
+	bisync = &BiSync{A: dirA, B: dirB, State: filepath.Join(testDir, "state.json"), Conflict: ConflictReport, DryRun: true}
+	_, err = bisync.Run(config)
+	assert.ErrorIs(t, err, ErrSyncSideEmpty, "試行でも片方が空であることが分かる")
+	bisync.DryRun = false
+	_, err = bisync.Run(config)
+	assert.ErrorIs(t, err, ErrSyncSideEmpty, "片方が空")
+	assert.Equal(t, "mod a", read(dirA, "mod_a.txt"), "削除しない")
+
+	// 削除するファイル数の上限
+	assert.NoError(t, os.Remove(dirB))
+	assert.NoError(t, os.Rename(dirB+".bak", dirB))
+	assert.NoError(t, os.Remove(filepath.Join(dirB, "mod_a.txt")))
+	assert.NoError(t, os.Remove(filepath.Join(dirB, "mod_b.txt")))
+	bisync = &BiSync{A: dirA, B: dirB, State: filepath.Join(testDir, "state.json"), Conflict: ConflictReport, MaxDelete: 1, DryRun: true}
+	report, err = bisync.Run(config)
+	assert.Error(t, err, "試行でも上限を超えることが分かる")
+	assert.Equal(t, []string{"mod_a.txt", "mod_b.txt"}, report.DeleteA, "試行の DELETE A")
+	bisync.DryRun = false
+	_, err = bisync.Run(config)
+	assert.Error(t, err, "上限を超える")
+	assert.Equal(t, "mod a", read(dirA, "mod_a.txt"), "削除しない")
+	bisync.Force = true
+	report, err = bisync.Run(config)
+	assert.NoError(t, err, "Force")
+	assert.Equal(t, []string{"mod_a.txt", "mod_b.txt"}, report.DeleteA, "DELETE A")
+	assert.Equal(t, "", read(dirA, "mod_a.txt"))
+}
+
+func TestMultiCopyRunner(t *testing.T) {
+	tt := testutil.TestCase{
+		TestFiles: []string{"same.txt", "new.txt", "a/new.txt"},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 準備(dst2 は差分がないファイルあり、dst3 は書き込めない)
+	srcDir := filepath.Join(testDir, "src")
+	testutil.PrepareDirs(t, tt, srcDir)
+	dsts := []string{filepath.Join(testDir, "dst1"), filepath.Join(testDir, "dst2"), filepath.Join(testDir, "dst3")}
+	assert.NoError(t, filecopy.CopyFile(filepath.Join(srcDir, "same.txt"), filepath.Join(dsts[1], "same.txt")))
+	assert.NoError(t, os.WriteFile(dsts[2], nil, 0644))
+	config := InitConfig()
+	config.Quiet = true
+	config.Retry = false
+
+	runner := &MultiCopyRunner{Destinations: dsts}
+	assert.NoError(t, RunMecha(srcDir, runner, config), "RunMecha")
+	for _, file := range tt.TestFiles {
+		for _, dst := range dsts[:2] {
+			assert.False(t, filecopy.IsFileDiff(filepath.Join(srcDir, file), filepath.Join(dst, file)), dst+": "+file)
+		}
+	}
+	job := runner.job
+	counts := [][2]int32{}
+	for _, dest := range job.rows {
+		counts = append(counts, [2]int32{dest.successCnt, dest.skipCnt})
+	}
+	assert.Equal(t, [][2]int32{{3, 0}, {2, 1}, {0, 0}}, counts, "コピー先ごとの集計")
+	assert.Len(t, job.rows[2].errorFiles, 3, "コピー先ごとのエラー")
+	assert.Len(t, job.errorFiles, 3, "エラー")
+
+	// リトライは失敗したコピー先のみコピーする
+	assert.NoError(t, os.Remove(dsts[2]))
+	for _, file := range job.errorFiles {
+		assert.NoError(t, runner.Retry(srcDir, file), file)
+		assert.False(t, filecopy.IsFileDiff(filepath.Join(srcDir, file), filepath.Join(dsts[2], file)), file)
+	}
+	assert.Empty(t, job.rows[2].errorFiles, "リトライ後のエラー")
+	assert.Equal(t, int32(3), job.rows[2].successCnt, "リトライ後の成功")
+}
+
+func TestRunMerge(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 準備(a と b は同じコピー先、c は a のサブディレクトリにコピーする)
+	dirs := []string{filepath.Join(testDir, "a"), filepath.Join(testDir, "b"), filepath.Join(testDir, "c"), filepath.Join(testDir, "d")}
+	files := map[string]string{
+		"a/common.txt": "a", "a/a.txt": "a", "a/sub/c.txt": "a",
+		"b/common.txt": "b", "b/b.txt": "b",
+		"c/c.txt": "c", "c/only.txt": "c",
+		"d/common.txt": "d",
+	}
+	for file, data := range files {
+		path := filepath.Join(testDir, file)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(path), os.ModePerm))
+		assert.NoError(t, os.WriteFile(path, []byte(data), 0644))
+	}
+	dstDir := filepath.Join(testDir, "dst")
+	config := InitConfig()
+	config.Quiet = true
+
+	sources := []MergeSource{{Dir: dirs[0]}, {Dir: dirs[1]}, {Dir: dirs[2], Prefix: "sub"}, {Dir: dirs[3], Prefix: "d"}}
+	collisions, err := RunMerge(sources, dstDir, config)
+	assert.NoError(t, err, "RunMerge")
+	assert.Equal(t, []MergeCollision{
+		{Path: "common.txt", Kept: dirs[0], Skipped: dirs[1]},
+		{Path: filepath.Join("sub", "c.txt"), Kept: dirs[0], Skipped: dirs[2]},
+	}, collisions, "同じコピー先のファイル")
+
+	expected := map[string]string{
+		"common.txt": "a", "a.txt": "a", "b.txt": "b",
+		"sub/c.txt": "a", "sub/only.txt": "c", "d/common.txt": "d",
+	}
+	for file, data := range expected {
+		actual, err := os.ReadFile(filepath.Join(dstDir, file))
+		assert.NoError(t, err, file)
+		assert.Equal(t, data, string(actual), file)
+	}
+
+	// コピー先のサブディレクトリは統合先の外を指定できない
+	_, err = RunMerge([]MergeSource{{Dir: dirs[0], Prefix: "../x"}}, dstDir, config)
+	assert.Error(t, err, "不正なサブディレクトリ")
+
+	// 「コピー元=サブディレクトリ」の指定
+	assert.Equal(t, MergeSource{Dir: "src", Prefix: "sub/dir"}, ParseMergeSource("src=sub/dir"))
+	assert.Equal(t, MergeSource{Dir: "src"}, ParseMergeSource("src"))
+	assert.Equal(t, MergeSource{Dir: "a=b", Prefix: "sub"}, ParseMergeSource("a=b=sub"), "最後の '=' で分割")
+}
+
+// 後に指定したコピー元が先にコピーした場合
+func TestMergeCollisionOrder(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	dirs := []string{filepath.Join(testDir, "a"), filepath.Join(testDir, "b")}
+	for i, dir := range dirs {
+		assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "new.txt"), []byte(dir), 0644))
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, "old.txt"), []byte(dir), 0644))
+		mtime := time.Now().Add(time.Duration(i-2) * time.Hour)
+		assert.NoError(t, os.Chtimes(filepath.Join(dir, "old.txt"), mtime, mtime))
+	}
+	dstDir := filepath.Join(testDir, "dst")
+	assert.NoError(t, os.MkdirAll(dstDir, os.ModePerm))
+	assert.NoError(t, os.WriteFile(filepath.Join(dstDir, "old.txt"), []byte("orig"), 0644))
+
+	run := func(config *Config) []*mergeRunner {
+		claims := &mergeClaims{names: dirs, files: map[string]*mergeClaim{}}
+		runners := []*mergeRunner{}
+		for i, dir := range dirs {
+			job := newJob(config, nil)
+			claims.jobs = append(claims.jobs, job)
+			runners = append(runners, &mergeRunner{index: i, src: dir, dst: dstDir, prefix: ".", job: job, claims: claims})
+		}
+		for _, i := range []int{1, 0} {
+			r := runners[i]
+			for _, file := range []string{"new.txt", "old.txt"} {
+				info, err := os.Stat(filepath.Join(r.src, file))
+				assert.NoError(t, err)
+				r.copyFile(filepath.Join(r.src, file), file, info, r.job)
+			}
+		}
+		return runners
+	}
+	read := func(file string) string {
+		data, _ := os.ReadFile(file)
+		return string(data)
+	}
+
+	// 上書きしない設定の場合は、先に指定したコピー元も実行前のファイルを上書きしない
+	config := InitConfig()
+	config.NoOverwrite = true
+	runners := run(config)
+	assert.Equal(t, dirs[0], read(filepath.Join(dstDir, "new.txt")), "先に指定したコピー元を優先")
+	assert.Equal(t, "orig", read(filepath.Join(dstDir, "old.txt")), "上書きしない")
+	assert.Equal(t, int32(1), runners[0].job.successFileCnt)
+	assert.Equal(t, int32(0), runners[1].job.successFileCnt, "上書きされたファイルはコピー数に含めない")
+	assert.Equal(t, int32(1), runners[1].job.skipCnt[SkipCollision])
+
+	// 実行前のファイルは後に指定したコピー元の書き込み時に退避する
+	assert.NoError(t, os.Remove(filepath.Join(dstDir, "new.txt")))
+	config = InitConfig()
+	config.BackupDir = filepath.Join(testDir, "backup")
+	runners = run(config)
+	assert.Equal(t, dirs[0], read(filepath.Join(dstDir, "new.txt")), "先に指定したコピー元を優先")
+	assert.Equal(t, dirs[0], read(filepath.Join(dstDir, "old.txt")), "先に指定したコピー元を優先")
+	assert.Equal(t, "orig", read(filepath.Join(config.BackupDir, "old.txt")), "実行前のファイルを退避")
+	assert.Equal(t, int32(2), runners[0].job.successFileCnt)
+	assert.Equal(t, int32(0), runners[1].job.successFileCnt)
+	assert.Equal(t, int32(2), runners[1].job.skipCnt[SkipCollision])
+}
+
+// リトライも最初のコピーと同じく差分、上書き、退避の設定に従う
+func TestMergeRetry(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	srcDir := filepath.Join(testDir, "src")
+	dstDir := filepath.Join(testDir, "dst")
+	for _, dir := range []string{srcDir, dstDir} {
+		assert.NoError(t, os.MkdirAll(dir, os.ModePerm))
+	}
+	assert.NoError(t, os.WriteFile(filepath.Join(srcDir, "file.txt"), []byte("new"), 0644))
+	read := func(file string) string {
+		data, _ := os.ReadFile(file)
+		return string(data)
+	}
+
+	// 上書きする場合は実行前のファイルを退避し、コピーしたコピー元の書き込み結果を更新する
+	assert.NoError(t, os.WriteFile(filepath.Join(dstDir, "file.txt"), []byte("orig"), 0644))
+	config := InitConfig()
+	config.BackupDir = filepath.Join(testDir, "backup")
+	claims := &mergeClaims{names: []string{srcDir}, files: map[string]*mergeClaim{}}
+	r := &mergeRunner{src: srcDir, dst: dstDir, prefix: ".", job: newJob(config, nil), claims: claims}
+	claims.get("file.txt").owner = 0
+	assert.NoError(t, r.Retry(srcDir, "file.txt"), "Retry")
+	assert.Equal(t, "new", read(filepath.Join(dstDir, "file.txt")), "コピー")
+	assert.Equal(t, "orig", read(filepath.Join(config.BackupDir, "file.txt")), "実行前のファイルを退避")
+	assert.Equal(t, copyDone, claims.get("file.txt").result, "書き込み結果")
+	assert.Equal(t, int32(1), r.job.successFileCnt)
+
+	// 上書きしない設定の場合はリトライでも上書きしない
+	assert.NoError(t, os.WriteFile(filepath.Join(dstDir, "file.txt"), []byte("orig"), 0644))
+	config = InitConfig()
+	config.NoOverwrite = true
+	r = &mergeRunner{src: srcDir, dst: dstDir, prefix: ".", job: newJob(config, nil)}
+	assert.NoError(t, r.Retry(srcDir, "file.txt"), "Retry")
+	assert.Equal(t, "orig", read(filepath.Join(dstDir, "file.txt")), "上書きしない")
+	assert.Equal(t, int32(0), r.job.successFileCnt)
+}
+
+func TestTarRunner(t *testing.T) {
+	tt := testutil.TestCase{
+		TestFiles: []string{"b.txt", "a.txt", "a/c.txt", "a/b/d.txt", "skip.log"},
+	}
+
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	// 準備
+	srcDir := filepath.Join(testDir, "src")
+	testutil.PrepareDirs(t, tt, srcDir)
+	mtime := time.Date(2024, 1, 2, 3, 4, 5, 600000000, time.UTC)
+	assert.NoError(t, os.Chtimes(filepath.Join(srcDir, "a.txt"), mtime, mtime))
+	assert.NoError(t, os.Chmod(filepath.Join(srcDir, "a.txt"), 0600))
+	config := InitConfig()
+	config.Quiet = true
+	config.TargetFiles = []string{"*.txt"}
+
+	archive := func() []byte {
+		var buf bytes.Buffer
+		runner := &TarRunner{Writer: &buf, Gzip: true}
+		assert.NoError(t, RunMecha(srcDir, runner, config), "RunMecha")
+		assert.NoError(t, runner.Err(), "Err")
+		return buf.Bytes()
+	}
+	data := archive()
+	assert.Equal(t, data, archive(), "同じ内容のアーカイブ")
+
+	// パス順にディレクトリ、対象ファイルを書き込む
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	assert.NoError(t, err)
+	tr := tar.NewReader(zr)
+	names := []string{}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoError(t, err)
+		names = append(names, hdr.Name)
+		if hdr.Name == "a.txt" {
+			assert.True(t, mtime.Equal(hdr.ModTime), "更新日時")
+			assert.Equal(t, int64(0600), hdr.Mode, "パーミッション")
+			content, err := io.ReadAll(tr)
+			assert.NoError(t, err)
+			expected, err := os.ReadFile(filepath.Join(srcDir, "a.txt"))
+			assert.NoError(t, err)
+			assert.Equal(t, expected, content, "ファイルの内容")
+		}
+	}
+	assert.Equal(t, []string{"a.txt", "a/", "a/b/", "a/b/d.txt", "a/c.txt", "b.txt"}, names)
+}
+
+func TestRunArchive(t *testing.T) {
+	os.RemoveAll(testDir)
+	defer os.RemoveAll(testDir)
+
+	// Infoログを非表示にする(テスト後に戻す)
+	testutil.DisableInfoLog()
+	defer testutil.EnableInfoLog()
+
+	files := map[string]string{"a.txt": "aaa", "sub/b.txt": "bbb", "sub/skip.log": "log", "x/c.txt": "ccc"}
+	names := []string{"a.txt", "sub/b.txt", "sub/skip.log", "x/c.txt"}
+	mtime := time.Date(2024, 1, 2, 3, 4, 6, 0, time.Local)
+
+	// zip、tar.gz を作成
+	assert.NoError(t, os.MkdirAll(testDir, os.ModePerm))
+	zipFile := filepath.Join(testDir, "src.zip")
+	f, err := os.Create(zipFile)
+	assert.NoError(t, err)
+	zw := zip.NewWriter(f)
+	for _, name := range names {
+		w, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: mtime})
+		assert.NoError(t, err)
+		_, err = w.Write([]byte(files[name]))
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, zw.Close())
+	assert.NoError(t, f.Close())
+
+	tarFile := filepath.Join(testDir, "src.tar.gz")
+	f, err = os.Create(tarFile)
+	assert.NoError(t, err)
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	for _, name := range names {
+		assert.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0600, Size: int64(len(files[name])), ModTime: mtime}))
+		_, err = tw.Write([]byte(files[name]))
+		assert.NoError(t, err)
+	}
+	// 同じパスのエントリは最後のエントリをコピーする
+	assert.NoError(t, tw.WriteHeader(&tar.Header{Name: "a.txt", Mode: 0600, Size: 3, ModTime: mtime}))
+	_, err = tw.Write([]byte("AAA"))
+	assert.NoError(t, err)
+	assert.NoError(t, tw.Close())
+	assert.NoError(t, gw.Close())
+	assert.NoError(t, f.Close())
+
+	config := InitConfig()
+	config.Quiet = true
+	config.TargetFiles = []string{"*.txt"}
+	config.ExcludeDirs = []string{"x"}
+
+	for _, src := range []string{zipFile, tarFile} {
+		dstDir := filepath.Join(testDir, "dst_"+filepath.Base(src))
+		assert.NoError(t, RunArchive(src, dstDir, config), src)
+		if src == tarFile {
+			files["a.txt"] = "AAA"
+			info, err := os.Stat(filepath.Join(dstDir, "a.txt"))
+			assert.NoError(t, err)
+			assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "ヘッダーのパーミッション")
+		}
+		for _, name := range names {
+			dstFile := filepath.Join(dstDir, name)
+			if name != "a.txt" && name != "sub/b.txt" {
+				assert.NoFileExists(t, dstFile, "対象外のファイル")
+				continue
+			}
+			data, err := os.ReadFile(dstFile)
+			assert.NoError(t, err, name)
+			assert.Equal(t, files[name], string(data), name)
+			info, err := os.Stat(dstFile)
+			assert.NoError(t, err)
+			assert.True(t, mtime.Equal(info.ModTime()), "更新日時: "+name)
+		}
+
+		// 差分がない(サイズ、更新日時が同じ)ファイルはコピーしない
+		dstFile := filepath.Join(dstDir, "a.txt")
+		assert.NoError(t, os.WriteFile(dstFile, []byte("zzz"), 0644))
+		assert.NoError(t, os.Chtimes(dstFile, mtime, mtime))
+		assert.NoError(t, RunArchive(src, dstDir, config), src)
+		data, err := os.ReadFile(dstFile)
+		assert.NoError(t, err)
+		assert.Equal(t, "zzz", string(data), "差分がないファイル")
+	}
+}